@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/google/uuid"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/auth"
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+)
+
+type accessTokenDenylist struct {
+	dbQueries *database.Queries
+}
+
+func (d accessTokenDenylist) IsRevoked(ctx context.Context, token auth.AccessToken) (bool, error) {
+	return d.dbQueries.IsAccessTokenRevoked(ctx,
+		database.IsAccessTokenRevokedParams{
+			Jti:      token.ID,
+			UserID:   token.UserID,
+			IssuedAt: token.IssuedAt,
+		})
+}
+
+func (cfg *apiConfig) validateAccessToken(ctx context.Context, token string) (auth.AccessToken, error) {
+	return cfg.jwtKeys.ValidateAccessToken(ctx, token,
+		accessTokenDenylist{dbQueries: cfg.dbQueries})
+}
+
+var (
+	errScopeNotGranted = errors.New("Token lacks the required scope")
+	errLoginRequired   = errors.New("This requires logging in to Chirpy")
+)
+
+func checkScope(granted []string, scope string) error {
+	if scope == "" {
+		return errLoginRequired
+	}
+	if !slices.Contains(granted, scope) {
+		return fmt.Errorf("%w: %v", errScopeNotGranted, scope)
+	}
+	return nil
+}
+
+// Authenticates a request by its bearer token, which is a JWT from logging
+// in, a JWT issued to an OAuth client, or a personal access token
+// A login JWT allows everything. The others must have been granted scope;
+// with no scope only a login JWT is accepted
+func (cfg *apiConfig) authenticate(r *http.Request, scope string) (uuid.UUID, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	if !auth.IsPersonalAccessToken(token) {
+		accessToken, err := cfg.validateAccessToken(r.Context(), token)
+		if err != nil {
+			return uuid.UUID{}, err
+		}
+		if accessToken.ClientID != "" {
+			err = checkScope(accessToken.Scopes, scope)
+			if err != nil {
+				return uuid.UUID{}, err
+			}
+		}
+		return accessToken.UserID, nil
+	}
+
+	if scope == "" {
+		return uuid.UUID{}, errLoginRequired
+	}
+
+	dbToken, err := cfg.dbQueries.UsePersonalAccessToken(r.Context(), auth.HashToken(token))
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("Invalid personal access token: %w", err)
+	}
+
+	err = checkScope(dbToken.Scopes, scope)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	return dbToken.UserID, nil
+}
+
+// A valid token used where it isn't allowed is forbidden; anything else
+// means the caller isn't authenticated
+func sendAuthenticationError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errScopeNotGranted) || errors.Is(err, errLoginRequired) {
+		chirpySendErrorResponse(w, 403, err.Error(), err)
+		return
+	}
+	chirpySendErrorResponse(w, 401, "Authorization failed", err)
+}
+
+// Stops every access token and personal access token issued to a user so far
+// from being accepted, and ends all of their sessions
+func (cfg *apiConfig) revokeUserTokens(ctx context.Context, userID uuid.UUID) error {
+	err := cfg.dbQueries.RevokeUserAccessTokens(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("Failed to revoke access tokens: %w", err)
+	}
+
+	err = cfg.dbQueries.RevokeAllSessions(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("Failed to revoke sessions: %w", err)
+	}
+
+	// A leaked personal access token would otherwise outlive a password
+	// change or reset
+	err = cfg.dbQueries.RevokeUserPersonalAccessTokens(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("Failed to revoke personal access tokens: %w", err)
+	}
+
+	return nil
+}
+
+// Revokes the access token the request was made with
+// The refresh token is revoked separately through /api/revoke
+func (cfg *apiConfig) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		chirpySendErrorResponse(w, 401, "Authorization failed", err)
+		return
+	}
+
+	accessToken, err := cfg.validateAccessToken(r.Context(), token)
+	if err != nil {
+		chirpySendErrorResponse(w, 401, "Authorization failed", err)
+		return
+	}
+
+	err = cfg.dbQueries.RevokeAccessToken(r.Context(),
+		database.RevokeAccessTokenParams{
+			Jti:       accessToken.ID,
+			UserID:    accessToken.UserID,
+			ExpiresAt: accessToken.ExpiresAt,
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to revoke token", err)
+		return
+	}
+
+	// Keeps the denylist from growing without bound
+	_, err = cfg.dbQueries.DeleteExpiredRevokedAccessTokens(r.Context())
+	if err != nil {
+		log.Printf("Error: Failed to delete expired revoked tokens: %v", err)
+	}
+
+	w.WriteHeader(204)
+	w.Write([]byte{})
+}
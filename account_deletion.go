@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+	"github.com/Tavis7/bootdev-chirpy/internal/mailer"
+)
+
+const deletedUsersPurgeInterval = time.Hour
+
+// ACCOUNT_DELETION_GRACE_PERIOD is how long a deleted account can still be
+// recovered by logging in, such as 720h; accounts are deleted right away if
+// it isn't set
+func loadAccountDeletionGracePeriod() (time.Duration, error) {
+	s := os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD")
+	if s == "" {
+		return 0, nil
+	}
+
+	gracePeriod, err := time.ParseDuration(s)
+	if err != nil || gracePeriod < 0 {
+		return 0, fmt.Errorf("Invalid ACCOUNT_DELETION_GRACE_PERIOD: %v", s)
+	}
+
+	return gracePeriod, nil
+}
+
+// Deletes accounts whose grace period has passed, until ctx is done
+// Runs even without a grace period, since deletions may have been scheduled
+// while one was configured
+func (cfg *apiConfig) purgeDeletedUsers(ctx context.Context) {
+	ticker := time.NewTicker(deletedUsersPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		userIDs, err := cfg.dbQueries.DeleteDueUsers(ctx)
+		if err != nil {
+			log.Printf("Error: Failed to delete users: %v", err)
+		}
+		for _, userID := range userIDs {
+			log.Printf("Deleted user %v", userID)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Called on every login; a user who comes back during the grace period keeps
+// their account
+func (cfg *apiConfig) cancelUserDeletion(ctx context.Context, userID uuid.UUID) error {
+	rows, err := cfg.dbQueries.CancelUserDeletion(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("Failed to cancel account deletion: %w", err)
+	}
+
+	if rows > 0 {
+		log.Printf("User %v logged in and cancelled the deletion of their account", userID)
+	}
+
+	return nil
+}
+
+// Deletes the caller's account once they confirm their password, and a
+// second factor if they have one
+// With a grace period the account is only scheduled for deletion and the
+// response is 202 saying when it will happen
+func (cfg *apiConfig) userDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	req := struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}{}
+
+	err = chirpyDecodeJsonRequest(r, &req)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		return
+	}
+
+	dbUserRow, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 401, "Authorization failed", err)
+		return
+	}
+
+	// A stolen access token alone mustn't be able to delete the account
+	if !cfg.reauthenticate(w, r, dbUserRow, req.Password, req.Code, req.RecoveryCode) {
+		return
+	}
+
+	if cfg.accountDeletionGracePeriod == 0 {
+		_, err = cfg.dbQueries.DeleteUser(r.Context(), userID)
+		if err != nil {
+			chirpySendErrorResponse(w, 500, "Failed to delete account", err)
+			return
+		}
+
+		log.Printf("Deleted user %v", userID)
+
+		w.WriteHeader(204)
+		w.Write([]byte{})
+		return
+	}
+
+	dbUserRow, err = cfg.dbQueries.ScheduleUserDeletion(r.Context(),
+		database.ScheduleUserDeletionParams{
+			ID: userID,
+			DeleteAfter: sql.NullTime{
+				Time:  time.Now().Add(cfg.accountDeletionGracePeriod),
+				Valid: true,
+			},
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to delete account", err)
+		return
+	}
+
+	// Nothing should keep using an account that is waiting to be deleted
+	err = cfg.revokeUserTokens(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to delete account", err)
+		return
+	}
+
+	deleteAfter := dbUserRow.DeleteAfter.Time
+
+	err = cfg.mailer.Send(r.Context(), mailer.Message{
+		To:      dbUserRow.Email,
+		Subject: "Your Chirpy account will be deleted",
+		Body: fmt.Sprintf("Your Chirpy account and everything in it will be deleted "+
+			"after %v.\n\nIf you change your mind, log in before then to keep it.\n",
+			deleteAfter.Format(time.RFC1123)),
+	})
+	if err != nil {
+		log.Printf("Error: Failed to send account deletion notice: %v", err)
+	}
+
+	response := struct {
+		DeleteAfter string `json:"delete_after"`
+	}{
+		DeleteAfter: deleteAfter.String(),
+	}
+
+	res, err := chirpyEncodeJsonResponse(202, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
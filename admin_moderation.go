@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+	"github.com/Tavis7/bootdev-chirpy/internal/moderation"
+)
+
+func loadModerationRulesFile(path string) ([]moderation.Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open moderation rules: %w", err)
+	}
+	defer f.Close()
+
+	rules, err := moderation.LoadRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load moderation rules from %v: %w", path, err)
+	}
+	return rules, nil
+}
+
+// Applies the configured rules plus those stored in the database, which take
+// precedence for the same word
+func (cfg *apiConfig) reloadModerationRules(ctx context.Context) error {
+	dbRules, err := cfg.dbQueries.GetModerationRules(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to get moderation rules: %w", err)
+	}
+
+	rules := append([]moderation.Rule{}, cfg.baseModerationRules...)
+	for _, rule := range dbRules {
+		rules = append(rules, moderation.Rule{
+			Word:   rule.Word,
+			Action: moderation.Action(rule.Action),
+		})
+	}
+
+	cfg.moderationRules.SetRules(rules)
+	return nil
+}
+
+// Files a report on behalf of the moderation rules; the chirp itself is
+// already stored so failures are only logged
+func (cfg *apiConfig) flagChirp(ctx context.Context, chirpID uuid.UUID, result moderation.Result) {
+	words := []string{}
+	for _, match := range result.Matches {
+		if match.Rule.Action == moderation.ActionFlag {
+			words = append(words, match.Text)
+		}
+	}
+
+	_, err := cfg.dbQueries.CreateReport(ctx,
+		database.CreateReportParams{
+			ChirpID: chirpID,
+			Reason:  "Matched flagged words: " + strings.Join(words, ", "),
+		})
+	if err != nil {
+		log.Printf("Error: Failed to flag chirp %v: %v", chirpID, err)
+	}
+}
+
+type moderationRule struct {
+	Word   string `json:"word"`
+	Action string `json:"action"`
+	Source string `json:"source"`
+}
+
+func (cfg *apiConfig) moderationRulesGetHandler(w http.ResponseWriter, r *http.Request) {
+	dbRules, err := cfg.dbQueries.GetModerationRules(r.Context())
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get moderation rules", err)
+		return
+	}
+
+	type ruleList struct {
+		Rules []moderationRule `json:"rules"`
+	}
+
+	response := ruleList{
+		Rules: []moderationRule{},
+	}
+
+	for _, rule := range cfg.baseModerationRules {
+		response.Rules = append(response.Rules, moderationRule{
+			Word:   rule.Word,
+			Action: string(rule.Action),
+			Source: "config",
+		})
+	}
+
+	for _, rule := range dbRules {
+		response.Rules = append(response.Rules, moderationRule{
+			Word:   rule.Word,
+			Action: rule.Action,
+			Source: "database",
+		})
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+// Creates or replaces the rule for a word
+func (cfg *apiConfig) moderationRuleSetHandler(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Action string `json:"action"`
+	}{}
+
+	err := chirpyDecodeJsonRequest(r, &req)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		return
+	}
+
+	action, err := moderation.ParseAction(req.Action)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid action", err)
+		return
+	}
+
+	word := moderation.Normalize(r.PathValue("word"))
+	if len(word) == 0 {
+		chirpySendErrorResponse(w, 400, "Invalid word", nil)
+		return
+	}
+
+	dbRule, err := cfg.dbQueries.SetModerationRule(r.Context(),
+		database.SetModerationRuleParams{
+			Word:   word,
+			Action: string(action),
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to save moderation rule", err)
+		return
+	}
+
+	err = cfg.reloadModerationRules(r.Context())
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to apply moderation rules", err)
+		return
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, moderationRule{
+		Word:   dbRule.Word,
+		Action: dbRule.Action,
+		Source: "database",
+	})
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+// Only rules stored in the database can be deleted at runtime
+func (cfg *apiConfig) moderationRuleDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	deleted, err := cfg.dbQueries.DeleteModerationRule(r.Context(),
+		moderation.Normalize(r.PathValue("word")))
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to delete moderation rule", err)
+		return
+	}
+
+	if deleted == 0 {
+		chirpySendErrorResponse(w, 404, "Rule not found", nil)
+		return
+	}
+
+	err = cfg.reloadModerationRules(r.Context())
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to apply moderation rules", err)
+		return
+	}
+
+	w.WriteHeader(204)
+	w.Write([]byte{})
+}
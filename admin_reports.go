@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+)
+
+// A report as seen by moderators, with the chirp it is about
+type moderationReport struct {
+	report
+	Chirp *chirp `json:"chirp,omitempty"`
+}
+
+type moderationAction struct {
+	ID          string `json:"id"`
+	CreatedAt   string `json:"created_at"`
+	ChirpID     string `json:"chirp_id"`
+	ReportID    string `json:"report_id,omitempty"`
+	Action      string `json:"action"`
+	Note        string `json:"note"`
+	ModeratorID string `json:"moderator_id,omitempty"`
+}
+
+func moderationActionFromDatabase(a database.ModerationAction) moderationAction {
+	response := moderationAction{
+		ID:        a.ID.String(),
+		CreatedAt: a.CreatedAt.String(),
+		ChirpID:   a.ChirpID.String(),
+		Action:    a.Action,
+		Note:      a.Note,
+	}
+	if a.ReportID.Valid {
+		response.ReportID = a.ReportID.UUID.String()
+	}
+	if a.ModeratorID.Valid {
+		response.ModeratorID = a.ModeratorID.UUID.String()
+	}
+	return response
+}
+
+// Moderators see hidden chirps in full
+func (cfg *apiConfig) moderationReports(ctx context.Context,
+	dbReports []database.Report) ([]moderationReport, error) {
+	response := []moderationReport{}
+
+	if len(dbReports) == 0 {
+		return response, nil
+	}
+
+	ids := []uuid.UUID{}
+	for _, r := range dbReports {
+		ids = append(ids, r.ChirpID)
+	}
+
+	dbChirps, err := cfg.dbQueries.GetChirpsByIDs(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get reported chirps: %w", err)
+	}
+
+	chirps := map[uuid.UUID]chirp{}
+	for _, c := range dbChirps {
+		chirps[c.ID] = chirpFromDatabase(c)
+	}
+
+	for _, r := range dbReports {
+		item := moderationReport{report: reportFromDatabase(r)}
+		c, ok := chirps[r.ChirpID]
+		if ok {
+			item.Chirp = &c
+		}
+		response = append(response, item)
+	}
+
+	return response, nil
+}
+
+// Records who did what to a chirp for the audit trail
+func (cfg *apiConfig) logModerationAction(ctx context.Context, moderatorID uuid.UUID,
+	chirpID uuid.UUID, reportID uuid.NullUUID, action, note string) error {
+	_, err := cfg.dbQueries.LogModerationAction(ctx,
+		database.LogModerationActionParams{
+			ChirpID:     chirpID,
+			ReportID:    reportID,
+			Action:      action,
+			Note:        note,
+			ModeratorID: uuid.NullUUID{UUID: moderatorID, Valid: true},
+		})
+	if err != nil {
+		return fmt.Errorf("Failed to log moderation action: %w", err)
+	}
+	return nil
+}
+
+type reportPage struct {
+	Reports    []moderationReport `json:"reports"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+	PrevCursor string             `json:"prev_cursor,omitempty"`
+}
+
+// Lists reports with ?status= (open by default), oldest first
+func (cfg *apiConfig) reportsGetHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if len(status) == 0 {
+		status = "open"
+	}
+
+	if status != "open" && status != "resolved" && status != "dismissed" {
+		chirpySendErrorResponse(w, 400, "Invalid status parameter", nil)
+		return
+	}
+
+	page, err := parsePageRequest(r)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid pagination parameters", err)
+		return
+	}
+
+	dbReports := []database.Report{}
+
+	if page.backwards() {
+		dbReports, err = cfg.dbQueries.ListReportsBefore(r.Context(),
+			database.ListReportsBeforeParams{
+				Status:          status,
+				CursorCreatedAt: page.cursorCreatedAt(),
+				CursorID:        page.cursorID(),
+				Limit:           page.fetchLimit(),
+			})
+	} else {
+		dbReports, err = cfg.dbQueries.ListReportsAfter(r.Context(),
+			database.ListReportsAfterParams{
+				Status:          status,
+				CursorCreatedAt: page.cursorCreatedAt(),
+				CursorID:        page.cursorID(),
+				Limit:           page.fetchLimit(),
+			})
+	}
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get reports", err)
+		return
+	}
+
+	dbReports, next, prev := paginate(dbReports, page,
+		func(r database.Report) pageCursor {
+			return pageCursor{CreatedAt: r.CreatedAt, ID: r.ID}
+		})
+
+	reports, err := cfg.moderationReports(r.Context(), dbReports)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get reports", err)
+		return
+	}
+
+	response := reportPage{
+		Reports:    reports,
+		NextCursor: encodePageCursor(next),
+		PrevCursor: encodePageCursor(prev),
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	setPageLinks(w, r, next, prev)
+	chirpySendResponse(w, res)
+}
+
+// A report together with the audit trail of its chirp
+func (cfg *apiConfig) reportGetHandler(w http.ResponseWriter, r *http.Request) {
+	reportID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "Report not found", err)
+		return
+	}
+
+	dbReport, err := cfg.dbQueries.GetReportByID(r.Context(), reportID)
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "Report not found", err)
+		return
+	}
+
+	reports, err := cfg.moderationReports(r.Context(), []database.Report{dbReport})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get report", err)
+		return
+	}
+
+	dbActions, err := cfg.dbQueries.GetModerationActionsByChirpID(r.Context(), dbReport.ChirpID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get report", err)
+		return
+	}
+
+	type reportDetail struct {
+		moderationReport
+		Actions []moderationAction `json:"actions"`
+	}
+
+	response := reportDetail{
+		moderationReport: reports[0],
+		Actions:          []moderationAction{},
+	}
+
+	for _, a := range dbActions {
+		response.Actions = append(response.Actions, moderationActionFromDatabase(a))
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+// Closes a report as "resolved" or "dismissed" without touching the chirp
+func (cfg *apiConfig) reportResolveHandler(w http.ResponseWriter, r *http.Request) {
+	moderatorID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	reportID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "Report not found", err)
+		return
+	}
+
+	req := struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}{}
+
+	err = chirpyDecodeJsonRequest(r, &req)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		return
+	}
+
+	action := ""
+	switch req.Status {
+	case "resolved":
+		action = "resolve"
+	case "dismissed":
+		action = "dismiss"
+	default:
+		chirpySendErrorResponse(w, 400, "Invalid status", nil)
+		return
+	}
+
+	dbReport, err := cfg.dbQueries.ResolveReport(r.Context(),
+		database.ResolveReportParams{
+			ID:     reportID,
+			Status: req.Status,
+		})
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = cfg.dbQueries.GetReportByID(r.Context(), reportID)
+		if err != nil {
+			chirpySendErrorResponse(w, 404, "Report not found", err)
+			return
+		}
+		chirpySendErrorResponse(w, 400, "Report already closed", nil)
+		return
+	}
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to resolve report", err)
+		return
+	}
+
+	err = cfg.logModerationAction(r.Context(), moderatorID, dbReport.ChirpID,
+		uuid.NullUUID{UUID: dbReport.ID, Valid: true}, action, req.Note)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to resolve report", err)
+		return
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, reportFromDatabase(dbReport))
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+// Hides a chirp from everyone but its author and resolves its open reports
+func (cfg *apiConfig) chirpHideHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.moderateChirp(w, r, "hide")
+}
+
+func (cfg *apiConfig) chirpUnhideHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.moderateChirp(w, r, "unhide")
+}
+
+// Replaces a chirp with a tombstone and resolves its open reports
+func (cfg *apiConfig) chirpRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.moderateChirp(w, r, "remove")
+}
+
+func (cfg *apiConfig) moderateChirp(w http.ResponseWriter, r *http.Request, action string) {
+	moderatorID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "Chirp not found", err)
+		return
+	}
+
+	req := struct {
+		Note string `json:"note"`
+	}{}
+
+	// The note is optional, so a plain POST or DELETE works too
+	err = chirpyDecodeOptionalJsonRequest(r, &req)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		return
+	}
+
+	dbChirp := database.Chirp{}
+
+	switch action {
+	case "hide":
+		dbChirp, err = cfg.dbQueries.HideChirp(r.Context(), chirpID)
+	case "unhide":
+		dbChirp, err = cfg.dbQueries.UnhideChirp(r.Context(), chirpID)
+	case "remove":
+		dbChirp, err = cfg.dbQueries.RemoveChirp(r.Context(), chirpID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		chirpySendErrorResponse(w, 404, "Chirp not found", err)
+		return
+	}
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to moderate chirp", err)
+		return
+	}
+
+	err = cfg.logModerationAction(r.Context(), moderatorID, chirpID, uuid.NullUUID{}, action, req.Note)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to moderate chirp", err)
+		return
+	}
+
+	if action != "unhide" {
+		resolved, err := cfg.dbQueries.ResolveChirpReports(r.Context(), chirpID)
+		if err != nil {
+			chirpySendErrorResponse(w, 500, "Failed to resolve reports", err)
+			return
+		}
+
+		for _, report := range resolved {
+			err = cfg.logModerationAction(r.Context(), moderatorID, chirpID,
+				uuid.NullUUID{UUID: report.ID, Valid: true}, "resolve", req.Note)
+			if err != nil {
+				chirpySendErrorResponse(w, 500, "Failed to resolve reports", err)
+				return
+			}
+		}
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, chirpFromDatabase(dbChirp))
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
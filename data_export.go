@@ -0,0 +1,269 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type exportedProfile struct {
+	ID               string `json:"id"`
+	CreatedAt        string `json:"created_at"`
+	UpdatedAt        string `json:"updated_at"`
+	Email            string `json:"email"`
+	EmailVerified    bool   `json:"email_verified"`
+	Handle           string `json:"handle"`
+	DisplayName      string `json:"display_name"`
+	Bio              string `json:"bio"`
+	AvatarURL        string `json:"avatar_url"`
+	IsChirpyRed      bool   `json:"is_chirpy_red"`
+	Role             string `json:"role"`
+	TwoFactorEnabled bool   `json:"two_factor_enabled"`
+	DeleteAfter      string `json:"delete_after,omitempty"`
+}
+
+type exportedChirp struct {
+	ID        string `json:"id"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+	Body      string `json:"body"`
+	InReplyTo string `json:"in_reply_to,omitempty"`
+	RechirpOf string `json:"rechirp_of,omitempty"`
+	DeletedAt string `json:"deleted_at,omitempty"`
+	HiddenAt  string `json:"hidden_at,omitempty"`
+}
+
+type exportedSession struct {
+	ID         string `json:"id"`
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at"`
+	ExpiresAt  string `json:"expires_at"`
+	RevokedAt  string `json:"revoked_at,omitempty"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+}
+
+type exportedSubscriptionEvent struct {
+	CreatedAt string `json:"created_at"`
+	Event     string `json:"event"`
+}
+
+type userExport struct {
+	ExportedAt         string                      `json:"exported_at"`
+	Profile            exportedProfile             `json:"profile"`
+	Chirps             []exportedChirp             `json:"chirps"`
+	Sessions           []exportedSession           `json:"sessions"`
+	SubscriptionEvents []exportedSubscriptionEvent `json:"subscription_events"`
+}
+
+func nullTimeString(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.String()
+}
+
+func nullUUIDString(id uuid.NullUUID) string {
+	if !id.Valid {
+		return ""
+	}
+	return id.UUID.String()
+}
+
+// Gathers everything about a user that is worth handing back to them
+// Password hashes, second factor secrets and tokens are left out
+func (cfg *apiConfig) collectUserExport(ctx context.Context, userID uuid.UUID) (userExport, error) {
+	dbUserRow, err := cfg.dbQueries.GetUserByID(ctx, userID)
+	if err != nil {
+		return userExport{}, fmt.Errorf("Failed to get user: %w", err)
+	}
+
+	dbChirps, err := cfg.dbQueries.ListUserChirps(ctx, userID)
+	if err != nil {
+		return userExport{}, fmt.Errorf("Failed to get chirps: %w", err)
+	}
+
+	dbSessions, err := cfg.dbQueries.ListSessionHistory(ctx, userID)
+	if err != nil {
+		return userExport{}, fmt.Errorf("Failed to get sessions: %w", err)
+	}
+
+	dbEvents, err := cfg.dbQueries.ListSubscriptionEvents(ctx, userID)
+	if err != nil {
+		return userExport{}, fmt.Errorf("Failed to get subscription history: %w", err)
+	}
+
+	export := userExport{
+		ExportedAt: time.Now().String(),
+		Profile: exportedProfile{
+			ID:               dbUserRow.ID.String(),
+			CreatedAt:        dbUserRow.CreatedAt.String(),
+			UpdatedAt:        dbUserRow.UpdatedAt.String(),
+			Email:            dbUserRow.Email,
+			EmailVerified:    dbUserRow.EmailVerifiedAt.Valid,
+			Handle:           dbUserRow.Handle,
+			DisplayName:      dbUserRow.DisplayName,
+			Bio:              dbUserRow.Bio,
+			AvatarURL:        dbUserRow.AvatarUrl,
+			IsChirpyRed:      dbUserRow.IsChirpyRed,
+			Role:             dbUserRow.Role,
+			TwoFactorEnabled: dbUserRow.TotpEnabledAt.Valid,
+			DeleteAfter:      nullTimeString(dbUserRow.DeleteAfter),
+		},
+		Chirps:             []exportedChirp{},
+		Sessions:           []exportedSession{},
+		SubscriptionEvents: []exportedSubscriptionEvent{},
+	}
+
+	for _, c := range dbChirps {
+		export.Chirps = append(export.Chirps, exportedChirp{
+			ID:        c.ID.String(),
+			CreatedAt: c.CreatedAt.String(),
+			UpdatedAt: c.UpdatedAt.String(),
+			Body:      c.Body,
+			InReplyTo: nullUUIDString(c.InReplyTo),
+			RechirpOf: nullUUIDString(c.RechirpOf),
+			DeletedAt: nullTimeString(c.DeletedAt),
+			HiddenAt:  nullTimeString(c.HiddenAt),
+		})
+	}
+
+	for _, s := range dbSessions {
+		export.Sessions = append(export.Sessions, exportedSession{
+			ID:         s.FamilyID.String(),
+			CreatedAt:  s.CreatedAt.String(),
+			LastUsedAt: s.LastUsedAt.String(),
+			ExpiresAt:  s.ExpiresAt.String(),
+			RevokedAt:  nullTimeString(s.RevokedAt),
+			UserAgent:  s.UserAgent,
+			IP:         s.Ip,
+		})
+	}
+
+	for _, e := range dbEvents {
+		export.SubscriptionEvents = append(export.SubscriptionEvents, exportedSubscriptionEvent{
+			CreatedAt: e.CreatedAt.String(),
+			Event:     e.Event,
+		})
+	}
+
+	return export, nil
+}
+
+func writeZipCSV(archive *zip.Writer, name string, records [][]string) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(f)
+	err = w.WriteAll(records)
+	if err != nil {
+		return fmt.Errorf("Failed to write %v: %w", name, err)
+	}
+
+	return nil
+}
+
+// Packs an export as a zip of export.json, holding everything, and a CSV
+// file for each list in it
+func (export userExport) archive() ([]byte, error) {
+	buf := bytes.Buffer{}
+	archive := zip.NewWriter(&buf)
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := archive.Create("export.json")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = f.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	chirps := [][]string{{"id", "created_at", "updated_at", "body", "in_reply_to",
+		"rechirp_of", "deleted_at", "hidden_at"}}
+	for _, c := range export.Chirps {
+		chirps = append(chirps, []string{c.ID, c.CreatedAt, c.UpdatedAt, c.Body, c.InReplyTo,
+			c.RechirpOf, c.DeletedAt, c.HiddenAt})
+	}
+
+	sessions := [][]string{{"id", "created_at", "last_used_at", "expires_at", "revoked_at",
+		"user_agent", "ip"}}
+	for _, s := range export.Sessions {
+		sessions = append(sessions, []string{s.ID, s.CreatedAt, s.LastUsedAt, s.ExpiresAt,
+			s.RevokedAt, s.UserAgent, s.IP})
+	}
+
+	events := [][]string{{"created_at", "event"}}
+	for _, e := range export.SubscriptionEvents {
+		events = append(events, []string{e.CreatedAt, e.Event})
+	}
+
+	err = writeZipCSV(archive, "chirps.csv", chirps)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeZipCSV(archive, "sessions.csv", sessions)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeZipCSV(archive, "subscription_events.csv", events)
+	if err != nil {
+		return nil, err
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Downloads a zip of the caller's data
+func (cfg *apiConfig) userExportHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	export, err := cfg.collectUserExport(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to export data", err)
+		return
+	}
+
+	// Built in memory so a failure part way through is still an error
+	// response rather than a truncated download
+	data, err := export.archive()
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to export data", err)
+		return
+	}
+
+	log.Printf("User %v exported their data", userID)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"chirpy-%v.zip\"", export.Profile.Handle))
+	w.WriteHeader(200)
+	w.Write(data)
+}
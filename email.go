@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/auth"
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+	"github.com/Tavis7/bootdev-chirpy/internal/mailer"
+)
+
+const (
+	passwordResetTokenDuration     = time.Hour
+	emailVerificationTokenDuration = time.Hour * 48
+)
+
+const (
+	emailTokenPasswordReset     = "password_reset"
+	emailTokenEmailVerification = "email_verification"
+)
+
+// Sends mail through SMTP_ADDR if it is set, authenticating with
+// SMTP_USERNAME and SMTP_PASSWORD. Otherwise mail is appended to MAIL_FILE,
+// or logged if that isn't set either, which is only allowed on the dev
+// platform since the log would then hold password reset links. MAIL_FROM is
+// the sender address
+func loadMailer(isDevPlatform bool) (mailer.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "chirpy@localhost"
+	}
+
+	smtpAddr := os.Getenv("SMTP_ADDR")
+	if smtpAddr != "" {
+		return mailer.SMTPMailer{
+			Addr:     smtpAddr,
+			From:     from,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}, nil
+	}
+
+	mailFile := os.Getenv("MAIL_FILE")
+	if mailFile == "" {
+		if !isDevPlatform {
+			return nil, fmt.Errorf("SMTP_ADDR or MAIL_FILE must be set outside the dev platform")
+		}
+		fmt.Println("Warning: logging mail, including password reset links")
+	}
+
+	return &mailer.FileMailer{
+		Path: mailFile,
+		From: from,
+	}, nil
+}
+
+// Mails a new single use token, replacing any of the same purpose the user
+// was sent before
+func (cfg *apiConfig) sendEmailToken(ctx context.Context, dbUser database.User,
+	purpose string, expiresIn time.Duration, subject, text, path string) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.dbQueries.DeleteEmailTokens(ctx,
+		database.DeleteEmailTokensParams{
+			UserID:  dbUser.ID,
+			Purpose: purpose,
+		})
+	if err != nil {
+		return fmt.Errorf("Failed to delete old tokens: %w", err)
+	}
+
+	err = cfg.dbQueries.CreateEmailToken(ctx,
+		database.CreateEmailTokenParams{
+			TokenHash: auth.HashToken(token),
+			UserID:    dbUser.ID,
+			Purpose:   purpose,
+			Email:     dbUser.Email,
+			ExpiresAt: time.Now().Add(expiresIn),
+		})
+	if err != nil {
+		return fmt.Errorf("Failed to store token: %w", err)
+	}
+
+	link := cfg.publicURL + path + "?token=" + url.QueryEscape(token)
+
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      dbUser.Email,
+		Subject: subject,
+		Body: fmt.Sprintf("%v\n\n%v\n\nThe link expires in %v. "+
+			"If you didn't ask for this you can ignore this email.\n",
+			text, link, expiresIn),
+	})
+}
+
+func (cfg *apiConfig) sendEmailVerification(ctx context.Context, dbUser database.User) error {
+	return cfg.sendEmailToken(ctx, dbUser, emailTokenEmailVerification,
+		emailVerificationTokenDuration, "Verify your Chirpy email address",
+		"Open this link to confirm this is your email address:", "/verify-email")
+}
+
+// Called when the email or password changes, so links sent before can't be
+// used to undo it
+func (cfg *apiConfig) deletePasswordResetTokens(ctx context.Context, userID uuid.UUID) error {
+	err := cfg.dbQueries.DeleteEmailTokens(ctx,
+		database.DeleteEmailTokensParams{
+			UserID:  userID,
+			Purpose: emailTokenPasswordReset,
+		})
+	if err != nil {
+		return fmt.Errorf("Failed to delete password reset tokens: %w", err)
+	}
+	return nil
+}
+
+// Mails a password reset link
+// Always succeeds so the response doesn't reveal which addresses have
+// accounts
+func (cfg *apiConfig) passwordResetRequestHandler(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Email string `json:"email"`
+	}{}
+
+	err := chirpyDecodeJsonRequest(r, &req)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		return
+	}
+
+	dbUserRow, err := cfg.dbQueries.GetUserByEmail(r.Context(), req.Email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		chirpySendErrorResponse(w, 500, "Failed to request password reset", err)
+		return
+	}
+
+	// Sent in the background so the response takes as long whether or not
+	// the address has an account
+	if err == nil {
+		go func() {
+			err := cfg.sendEmailToken(context.Background(), dbUserRow, emailTokenPasswordReset,
+				passwordResetTokenDuration, "Reset your Chirpy password",
+				"Open this link to choose a new password:", "/reset-password")
+			if err != nil {
+				log.Printf("Error: Failed to send password reset: %v", err)
+			}
+		}()
+	}
+
+	w.WriteHeader(204)
+	w.Write([]byte{})
+}
+
+// Sets a new password with a token from a reset email and logs out every
+// device
+func (cfg *apiConfig) passwordResetConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}{}
+
+	err := chirpyDecodeJsonRequest(r, &req)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		return
+	}
+
+	if !cfg.checkPasswordPolicy(w, req.Password) {
+		return
+	}
+
+	passwordHash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to reset password", err)
+		return
+	}
+
+	dbToken, err := cfg.dbQueries.UseEmailToken(r.Context(),
+		database.UseEmailTokenParams{
+			TokenHash: auth.HashToken(req.Token),
+			Purpose:   emailTokenPasswordReset,
+		})
+	if errors.Is(err, sql.ErrNoRows) {
+		chirpySendErrorResponse(w, 400, "Invalid or expired token", err)
+		return
+	}
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to reset password", err)
+		return
+	}
+
+	dbUserRow, err := cfg.dbQueries.GetUserByID(r.Context(), dbToken.UserID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to reset password", err)
+		return
+	}
+
+	// A link mailed to an address the user has since moved away from
+	// mustn't still work for whoever reads that mailbox now
+	if dbUserRow.Email != dbToken.Email {
+		chirpySendErrorResponse(w, 400, "Invalid or expired token",
+			fmt.Errorf("Token was sent to a previous email address"))
+		return
+	}
+
+	_, err = cfg.dbQueries.UpdateUser(r.Context(),
+		database.UpdateUserParams{
+			ID:             dbToken.UserID,
+			HashedPassword: sql.NullString{String: passwordHash, Valid: true},
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to reset password", err)
+		return
+	}
+
+	err = cfg.revokeUserTokens(r.Context(), dbToken.UserID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to reset password", err)
+		return
+	}
+
+	err = cfg.deletePasswordResetTokens(r.Context(), dbToken.UserID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to reset password", err)
+		return
+	}
+
+	// Following the link proves the user reads mail at this address
+	_, err = cfg.dbQueries.VerifyUserEmail(r.Context(),
+		database.VerifyUserEmailParams{
+			ID:    dbToken.UserID,
+			Email: dbToken.Email,
+		})
+	if err != nil {
+		log.Printf("Error: Failed to verify email: %v", err)
+	}
+
+	w.WriteHeader(204)
+	w.Write([]byte{})
+}
+
+func (cfg *apiConfig) emailVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Token string `json:"token"`
+	}{}
+
+	err := chirpyDecodeJsonRequest(r, &req)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		return
+	}
+
+	dbToken, err := cfg.dbQueries.UseEmailToken(r.Context(),
+		database.UseEmailTokenParams{
+			TokenHash: auth.HashToken(req.Token),
+			Purpose:   emailTokenEmailVerification,
+		})
+	if errors.Is(err, sql.ErrNoRows) {
+		chirpySendErrorResponse(w, 400, "Invalid or expired token", err)
+		return
+	}
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to verify email", err)
+		return
+	}
+
+	rows, err := cfg.dbQueries.VerifyUserEmail(r.Context(),
+		database.VerifyUserEmailParams{
+			ID:    dbToken.UserID,
+			Email: dbToken.Email,
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to verify email", err)
+		return
+	}
+
+	if rows == 0 {
+		dbUserRow, err := cfg.dbQueries.GetUserByID(r.Context(), dbToken.UserID)
+		if err != nil || dbUserRow.Email != dbToken.Email {
+			chirpySendErrorResponse(w, 400, "Email address has changed", err)
+			return
+		}
+		// Already verified
+	}
+
+	w.WriteHeader(204)
+	w.Write([]byte{})
+}
+
+// Mails the caller a new verification link
+func (cfg *apiConfig) emailVerifyResendHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	dbUserRow, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 401, "Authorization failed", err)
+		return
+	}
+
+	if dbUserRow.EmailVerifiedAt.Valid {
+		chirpySendErrorResponse(w, 400, "Email already verified", nil)
+		return
+	}
+
+	err = cfg.sendEmailVerification(r.Context(), dbUserRow)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to send verification email", err)
+		return
+	}
+
+	w.WriteHeader(204)
+	w.Write([]byte{})
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/auth"
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+)
+
+func (cfg *apiConfig) followCreateHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, auth.ScopeProfileWrite)
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	followeeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "User not found", err)
+		return
+	}
+
+	if followeeID == userID {
+		chirpySendErrorResponse(w, 400, "Cannot follow yourself", nil)
+		return
+	}
+
+	err = cfg.dbQueries.FollowUser(r.Context(),
+		database.FollowUserParams{
+			FollowerID: userID,
+			FolloweeID: followeeID,
+		})
+	if err != nil {
+		e, ok := err.(*pq.Error)
+		if ok && e.Code.Name() == "foreign_key_violation" {
+			chirpySendErrorResponse(w, 404, "User not found", e)
+			return
+		}
+		chirpySendErrorResponse(w, 500, "Failed to follow user", err)
+		return
+	}
+
+	w.WriteHeader(204)
+	w.Write([]byte{})
+}
+
+func (cfg *apiConfig) followDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, auth.ScopeProfileWrite)
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	followeeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "User not found", err)
+		return
+	}
+
+	err = cfg.dbQueries.UnfollowUser(r.Context(),
+		database.UnfollowUserParams{
+			FollowerID: userID,
+			FolloweeID: followeeID,
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to unfollow user", err)
+		return
+	}
+
+	w.WriteHeader(204)
+	w.Write([]byte{})
+}
+
+type follow struct {
+	UserID     string `json:"user_id"`
+	FollowedAt string `json:"followed_at"`
+}
+
+type followPage struct {
+	Users      []follow `json:"users"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+	PrevCursor string   `json:"prev_cursor,omitempty"`
+}
+
+// Lists the users following the user at /followers, or the users they follow
+// at /following, newest first
+// One pattern serves both so it doesn't conflict with /api/users/by-handle/
+func (cfg *apiConfig) followListGetHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.PathValue("list") {
+	case "followers":
+		cfg.sendFollowPage(w, r, true)
+	case "following":
+		cfg.sendFollowPage(w, r, false)
+	default:
+		chirpySendErrorResponse(w, 404, "Not found", nil)
+	}
+}
+
+func (cfg *apiConfig) sendFollowPage(w http.ResponseWriter, r *http.Request, followers bool) {
+	userID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "User not found", err)
+		return
+	}
+
+	page, err := parsePageRequest(r)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid pagination parameters", err)
+		return
+	}
+
+	dbFollows := []database.Follow{}
+
+	switch {
+	case followers && page.backwards():
+		dbFollows, err = cfg.dbQueries.ListFollowersAfter(r.Context(),
+			database.ListFollowersAfterParams{
+				UserID:          userID,
+				CursorCreatedAt: page.cursorCreatedAt(),
+				CursorID:        page.cursorID(),
+				Limit:           page.fetchLimit(),
+			})
+	case followers:
+		dbFollows, err = cfg.dbQueries.ListFollowersBefore(r.Context(),
+			database.ListFollowersBeforeParams{
+				UserID:          userID,
+				CursorCreatedAt: page.cursorCreatedAt(),
+				CursorID:        page.cursorID(),
+				Limit:           page.fetchLimit(),
+			})
+	case page.backwards():
+		dbFollows, err = cfg.dbQueries.ListFollowingAfter(r.Context(),
+			database.ListFollowingAfterParams{
+				UserID:          userID,
+				CursorCreatedAt: page.cursorCreatedAt(),
+				CursorID:        page.cursorID(),
+				Limit:           page.fetchLimit(),
+			})
+	default:
+		dbFollows, err = cfg.dbQueries.ListFollowingBefore(r.Context(),
+			database.ListFollowingBeforeParams{
+				UserID:          userID,
+				CursorCreatedAt: page.cursorCreatedAt(),
+				CursorID:        page.cursorID(),
+				Limit:           page.fetchLimit(),
+			})
+	}
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get follows", err)
+		return
+	}
+
+	// The listed user is whoever is on the other side of the relationship
+	other := func(f database.Follow) uuid.UUID {
+		if followers {
+			return f.FollowerID
+		}
+		return f.FolloweeID
+	}
+
+	dbFollows, next, prev := paginate(dbFollows, page,
+		func(f database.Follow) pageCursor {
+			return pageCursor{CreatedAt: f.CreatedAt, ID: other(f)}
+		})
+
+	response := followPage{
+		Users:      []follow{},
+		NextCursor: encodePageCursor(next),
+		PrevCursor: encodePageCursor(prev),
+	}
+
+	for _, f := range dbFollows {
+		response.Users = append(response.Users, follow{
+			UserID:     other(f).String(),
+			FollowedAt: f.CreatedAt.String(),
+		})
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	setPageLinks(w, r, next, prev)
+	chirpySendResponse(w, res)
+}
+
+// Chirps by the caller and everyone they follow, newest first
+func (cfg *apiConfig) timelineGetHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, auth.ScopeChirpsRead)
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	page, err := parsePageRequest(r)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid pagination parameters", err)
+		return
+	}
+
+	dbChirps := []database.Chirp{}
+
+	if page.backwards() {
+		dbChirps, err = cfg.dbQueries.ListTimelineAfter(r.Context(),
+			database.ListTimelineAfterParams{
+				UserID:          userID,
+				CursorCreatedAt: page.cursorCreatedAt(),
+				CursorID:        page.cursorID(),
+				Limit:           page.fetchLimit(),
+			})
+	} else {
+		dbChirps, err = cfg.dbQueries.ListTimelineBefore(r.Context(),
+			database.ListTimelineBeforeParams{
+				UserID:          userID,
+				CursorCreatedAt: page.cursorCreatedAt(),
+				CursorID:        page.cursorID(),
+				Limit:           page.fetchLimit(),
+			})
+	}
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get timeline", err)
+		return
+	}
+
+	dbChirps, next, prev := paginate(dbChirps, page,
+		func(c database.Chirp) pageCursor {
+			return pageCursor{CreatedAt: c.CreatedAt, ID: c.ID}
+		})
+
+	chirps, err := cfg.chirpResponses(r.Context(), dbChirps,
+		uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get timeline", err)
+		return
+	}
+
+	response := chirpPage{
+		Chirps:     chirps,
+		NextCursor: encodePageCursor(next),
+		PrevCursor: encodePageCursor(prev),
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	setPageLinks(w, r, next, prev)
+	chirpySendResponse(w, res)
+}
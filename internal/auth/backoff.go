@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"time"
+)
+
+// How long to refuse logins after a run of failures
+// The first FreeAttempts failures cost nothing, each one after that doubles
+// the wait from BaseDelay up to MaxDelay, and from LockoutAfter failures on
+// the wait is LockoutDuration
+type BackoffPolicy struct {
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutAfter    int
+	LockoutDuration time.Duration
+}
+
+func (p BackoffPolicy) Delay(failures int) time.Duration {
+	if failures >= p.LockoutAfter {
+		return p.LockoutDuration
+	}
+
+	if failures < p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for range failures - p.FreeAttempts {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return delay
+}
+
+// Whether a run of failures has reached a lockout rather than a short delay
+func (p BackoffPolicy) LockedOut(failures int) bool {
+	return failures >= p.LockoutAfter
+}
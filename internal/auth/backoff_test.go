@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffPolicy(t *testing.T) {
+	p := BackoffPolicy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: time.Hour,
+	}
+
+	cases := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{6, 8 * time.Second},
+		{8, 32 * time.Second},
+		{9, time.Minute},
+		{10, time.Hour},
+		{50, time.Hour},
+	}
+
+	for _, c := range cases {
+		delay := p.Delay(c.failures)
+		if delay != c.delay {
+			t.Errorf("%v failures: expected %v but got %v", c.failures, c.delay, delay)
+		}
+	}
+
+	if p.LockedOut(9) || !p.LockedOut(10) {
+		t.Errorf("Lockout should start at %v failures", p.LockoutAfter)
+	}
+}
@@ -1,7 +1,7 @@
 package auth
 
 import (
-	"encoding/base64"
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -11,52 +11,211 @@ import (
 	"github.com/google/uuid"
 )
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+const (
+	DefaultIssuer   = "chirpy"
+	DefaultAudience = "chirpy"
+	DefaultLeeway   = 30 * time.Second
+)
+
+// The validated contents of an access token
+// Tokens from logging in have no ClientID or Scopes and allow everything;
+// tokens issued to an OAuth client only allow their scopes
+type AccessToken struct {
+	UserID    uuid.UUID
+	ID        string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	ClientID  string
+	Scopes    []string
+	// Only set on tokens from logging in, for users above RoleUser
+	Role string
+}
+
+// Claims from RFC 9068, the JWT profile for OAuth access tokens
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	Role     string `json:"role,omitempty"`
+}
+
+// Decides whether an otherwise valid access token has been revoked
+type Denylist interface {
+	IsRevoked(ctx context.Context, token AccessToken) (bool, error)
+}
+
+func (k *Keyring) MakeJWT(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	return k.MakeJWTWithRole(userID, RoleUser, expiresIn)
+}
+
+// A login token carrying the user's role, for authorization checks that
+// don't go to the database
+func (k *Keyring) MakeJWTWithRole(userID uuid.UUID, role string, expiresIn time.Duration) (string, error) {
+	_, err := ParseRole(role)
+	if err != nil {
+		return "", err
+	}
+	if role == RoleUser {
+		role = ""
+	}
+	return k.makeToken(userID, k.Audience, expiresIn, "", nil, role)
+}
+
+// An access token issued to an OAuth client on a user's behalf
+func (k *Keyring) MakeScopedJWT(userID uuid.UUID, clientID string, scopes []string,
+	expiresIn time.Duration) (string, error) {
+	if len(clientID) == 0 || len(scopes) == 0 {
+		return "", fmt.Errorf("Scoped tokens need a client and at least one scope")
+	}
+	return k.makeToken(userID, k.Audience, expiresIn, clientID, scopes, "")
+}
+
+// Challenge tokens prove the password step of a two-step login was passed
+// They have their own audience so they never work as access tokens
+func (k *Keyring) MakeMFAToken(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	return k.makeToken(userID, k.mfaAudience(), expiresIn, "", nil, "")
+}
+
+func (k *Keyring) mfaAudience() string {
+	return k.Audience + ":mfa"
+}
+
+func (k *Keyring) makeToken(userID uuid.UUID, audience string, expiresIn time.Duration,
+	clientID string, scopes []string, role string) (string, error) {
 	now := time.Now()
 	expires := now.Add(expiresIn)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256,
-		jwt.RegisteredClaims{
-			Issuer:    "chirpy",
-			IssuedAt:  &jwt.NumericDate{now},
-			ExpiresAt: &jwt.NumericDate{expires},
+	return k.Sign(tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    k.Issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
 			Subject:   userID.String(),
-		})
+			ID:        uuid.NewString(),
+		},
+		Scope:    strings.Join(scopes, " "),
+		ClientID: clientID,
+		Role:     role,
+	})
+}
+
+// Checks the signature, algorithm, issuer, audience and lifetime of a token
+// Every claim MakeJWT sets is required
+func (k *Keyring) ParseAccessToken(tokenString string) (AccessToken, error) {
+	return k.parseToken(tokenString, k.Audience)
+}
+
+func (k *Keyring) ParseMFAToken(tokenString string) (AccessToken, error) {
+	return k.parseToken(tokenString, k.mfaAudience())
+}
 
-	key, err := base64.StdEncoding.DecodeString(tokenSecret)
+func (k *Keyring) parseToken(tokenString, audience string) (AccessToken, error) {
+	claims := tokenClaims{}
+	_, err := k.Parse(tokenString, &claims,
+		jwt.WithIssuer(k.Issuer),
+		jwt.WithAudience(audience),
+		jwt.WithLeeway(k.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
 	if err != nil {
-		return "", err
+		return AccessToken{}, fmt.Errorf("Error parsing token with claims: %w", err)
 	}
 
-	ss, err := token.SignedString(key)
+	id, err := uuid.Parse(claims.Subject)
 	if err != nil {
-		return "", err
+		return AccessToken{}, fmt.Errorf("Error parsing subject: %w", err)
 	}
-	return ss, nil
+
+	if len(claims.ID) == 0 {
+		return AccessToken{}, fmt.Errorf("Token has no jti claim")
+	}
+
+	if claims.IssuedAt == nil {
+		return AccessToken{}, fmt.Errorf("Token has no iat claim")
+	}
+
+	if (len(claims.ClientID) == 0) != (len(claims.Scope) == 0) {
+		return AccessToken{}, fmt.Errorf("Token must have both or neither of scope and client_id")
+	}
+
+	// Roles are never delegated to other apps
+	if len(claims.Role) > 0 && (len(claims.ClientID) > 0 || roleRank(claims.Role) < 0) {
+		return AccessToken{}, fmt.Errorf("Invalid role claim: %v", claims.Role)
+	}
+
+	token := AccessToken{
+		UserID:    id,
+		ID:        claims.ID,
+		IssuedAt:  claims.IssuedAt.Time,
+		ExpiresAt: claims.ExpiresAt.Time,
+		ClientID:  claims.ClientID,
+		Role:      claims.Role,
+	}
+	if len(claims.Scope) > 0 {
+		token.Scopes = strings.Fields(claims.Scope)
+	}
+	return token, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+// ParseAccessToken plus a check against the denylist
+func (k *Keyring) ValidateAccessToken(ctx context.Context, tokenString string, denylist Denylist) (AccessToken, error) {
+	token, err := k.ParseAccessToken(tokenString)
+	if err != nil {
+		return AccessToken{}, err
+	}
 
-	keyFunc := func(token *jwt.Token) (any, error) {
-		return base64.StdEncoding.DecodeString(tokenSecret)
+	revoked, err := denylist.IsRevoked(ctx, token)
+	if err != nil {
+		return AccessToken{}, fmt.Errorf("Failed to check token revocation: %w", err)
+	}
+	if revoked {
+		return AccessToken{}, fmt.Errorf("Token %v has been revoked", token.ID)
 	}
 
-	claims := jwt.RegisteredClaims{}
-	t, err := jwt.ParseWithClaims(tokenString, &claims, keyFunc)
+	return token, nil
+}
+
+func (k *Keyring) ValidateJWT(tokenString string) (uuid.UUID, error) {
+	token, err := k.ParseAccessToken(tokenString)
 	if err != nil {
-		return uuid.UUID{}, fmt.Errorf("Error parsing token with claims: %w", err)
+		return uuid.UUID{}, err
 	}
+	return token.UserID, nil
+}
 
-	subject, err := t.Claims.GetSubject()
+// A keyring holding just tokenSecret, which also verifies tokens issued
+// before key IDs were added
+func SecretKeyring(tokenSecret string) (*Keyring, error) {
+	key, err := ParseHMACSecret(tokenSecret)
 	if err != nil {
-		return uuid.UUID{}, fmt.Errorf("Error getting subject: %w", err)
+		return nil, err
 	}
 
-	id, err := uuid.Parse(subject)
+	keys := NewKeyring()
+	err = keys.Add(key, true)
 	if err != nil {
-		return uuid.UUID{}, fmt.Errorf("Error parsing subject: %w", err)
+		return nil, err
 	}
+	keys.SetLegacyKey(key)
 
-	return id, nil
+	return keys, nil
+}
+
+func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	keys, err := SecretKeyring(tokenSecret)
+	if err != nil {
+		return "", err
+	}
+	return keys.MakeJWT(userID, expiresIn)
+}
+
+func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	keys, err := SecretKeyring(tokenSecret)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return keys.ValidateJWT(tokenString)
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -68,12 +227,10 @@ func GetBearerToken(headers http.Header) (string, error) {
 	prefix := "Bearer "
 	token, ok := strings.CutPrefix(header, prefix)
 	if ok != true {
-		return "", fmt.Errorf("Authorization header missing '%v' prefix: %v",
-			prefix, token)
+		return "", fmt.Errorf("Authorization header missing '%v' prefix", prefix)
 	}
 
 	token = strings.TrimSpace(token)
 
-	fmt.Printf("Header: %v\n", header)
 	return token, nil
 }
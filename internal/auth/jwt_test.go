@@ -1,6 +1,9 @@
 package auth
 
 import (
+	"context"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"net/http"
 	"testing"
@@ -77,3 +80,301 @@ func TestGetBearerToken(t *testing.T) {
 		t.Errorf("Expected 'abc' but got '%v'", token)
 	}
 }
+
+type fakeDenylist map[string]bool
+
+func (d fakeDenylist) IsRevoked(ctx context.Context, token AccessToken) (bool, error) {
+	revoked, ok := d[token.ID]
+	if !ok {
+		return false, fmt.Errorf("Unexpected token: %v", token.ID)
+	}
+	return revoked, nil
+}
+
+func testKeyring(t *testing.T) *Keyring {
+	keys, err := SecretKeyring(key)
+	if err != nil {
+		t.Fatalf("Test is broken: %v", err)
+	}
+	return keys
+}
+
+// Claims as MakeJWT would set them, for tests to break one at a time
+func validClaims(id uuid.UUID) jwt.RegisteredClaims {
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		Issuer:    DefaultIssuer,
+		Audience:  jwt.ClaimStrings{DefaultAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		Subject:   id.String(),
+		ID:        "jti",
+	}
+}
+
+func TestParseAccessTokenRejections(t *testing.T) {
+	id := uuid.MustParse("253be0c3-c9e8-4d34-b6a9-9a8211884bc3")
+	keys := testKeyring(t)
+
+	s, err := keys.Sign(validClaims(id))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	token, err := keys.ParseAccessToken(s)
+	if err != nil {
+		t.Fatalf("Valid claims should be accepted: %v", err)
+	}
+	if token.UserID != id || token.ID != "jti" {
+		t.Errorf("Unexpected token: %+v", token)
+	}
+
+	cases := []struct {
+		name   string
+		modify func(c *jwt.RegisteredClaims)
+	}{
+		{"wrong issuer", func(c *jwt.RegisteredClaims) { c.Issuer = "not-chirpy" }},
+		{"missing issuer", func(c *jwt.RegisteredClaims) { c.Issuer = "" }},
+		{"wrong audience", func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"other"} }},
+		{"missing audience", func(c *jwt.RegisteredClaims) { c.Audience = nil }},
+		{"expired", func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-DefaultLeeway - time.Minute))
+		}},
+		{"missing expiry", func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil }},
+		{"issued in the future", func(c *jwt.RegisteredClaims) {
+			c.IssuedAt = jwt.NewNumericDate(time.Now().Add(DefaultLeeway + time.Minute))
+		}},
+		{"missing issued at", func(c *jwt.RegisteredClaims) { c.IssuedAt = nil }},
+		{"missing jti", func(c *jwt.RegisteredClaims) { c.ID = "" }},
+		{"invalid subject", func(c *jwt.RegisteredClaims) { c.Subject = "nobody" }},
+	}
+
+	for _, tc := range cases {
+		claims := validClaims(id)
+		tc.modify(&claims)
+
+		s, err := keys.Sign(claims)
+		if err != nil {
+			t.Errorf("%v: %v", tc.name, err)
+			continue
+		}
+
+		_, err = keys.ParseAccessToken(s)
+		if err == nil {
+			t.Errorf("%v: token should be rejected", tc.name)
+		}
+	}
+}
+
+func TestParseAccessTokenLeeway(t *testing.T) {
+	id := uuid.MustParse("253be0c3-c9e8-4d34-b6a9-9a8211884bc3")
+	keys := testKeyring(t)
+
+	claims := validClaims(id)
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-DefaultLeeway / 2))
+
+	s, err := keys.Sign(claims)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	_, err = keys.ParseAccessToken(s)
+	if err != nil {
+		t.Errorf("Token expired within the leeway should be accepted: %v", err)
+	}
+
+	keys.Leeway = 0
+	_, err = keys.ParseAccessToken(s)
+	if err == nil {
+		t.Errorf("Expired token should be rejected without leeway")
+	}
+}
+
+func TestParseAccessTokenPinsAlgorithm(t *testing.T) {
+	id := uuid.MustParse("253be0c3-c9e8-4d34-b6a9-9a8211884bc3")
+	keys := testKeyring(t)
+
+	hmacKey, err := ParseHMACSecret(key)
+	if err != nil {
+		t.Fatalf("Test is broken: %v", err)
+	}
+
+	// Same secret and kid, different algorithm
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, validClaims(id))
+	token.Header["kid"] = hmacKey.ID
+	s, err := token.SignedString(hmacKey.signingKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	_, err = keys.ParseAccessToken(s)
+	if err == nil {
+		t.Errorf("HS512 token should be rejected")
+	}
+
+	token = jwt.NewWithClaims(jwt.SigningMethodNone, validClaims(id))
+	s, err = token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	_, err = keys.ParseAccessToken(s)
+	if err == nil {
+		t.Errorf("Unsigned token should be rejected")
+	}
+}
+
+func TestValidateAccessTokenDenylist(t *testing.T) {
+	id := uuid.MustParse("253be0c3-c9e8-4d34-b6a9-9a8211884bc3")
+	keys := testKeyring(t)
+
+	s, err := keys.MakeJWT(id, time.Minute)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	token, err := keys.ParseAccessToken(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	_, err = keys.ValidateAccessToken(context.Background(), s,
+		fakeDenylist{token.ID: false})
+	if err != nil {
+		t.Errorf("Token not on the denylist should be accepted: %v", err)
+	}
+
+	_, err = keys.ValidateAccessToken(context.Background(), s,
+		fakeDenylist{token.ID: true})
+	if err == nil {
+		t.Errorf("Revoked token should be rejected")
+	}
+
+	_, err = keys.ValidateAccessToken(context.Background(), s, fakeDenylist{})
+	if err == nil {
+		t.Errorf("Token should be rejected when the denylist fails")
+	}
+}
+
+func TestMFATokenAudience(t *testing.T) {
+	id := uuid.MustParse("253be0c3-c9e8-4d34-b6a9-9a8211884bc3")
+	keys := testKeyring(t)
+
+	challenge, err := keys.MakeMFAToken(id, time.Minute)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	access, err := keys.MakeJWT(id, time.Minute)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	_, err = keys.ParseMFAToken(challenge)
+	if err != nil {
+		t.Errorf("Challenge token should parse: %v", err)
+	}
+
+	_, err = keys.ParseAccessToken(challenge)
+	if err == nil {
+		t.Errorf("Challenge token should not work as an access token")
+	}
+
+	_, err = keys.ParseMFAToken(access)
+	if err == nil {
+		t.Errorf("Access token should not work as a challenge token")
+	}
+}
+
+func TestScopedJWT(t *testing.T) {
+	id := uuid.MustParse("253be0c3-c9e8-4d34-b6a9-9a8211884bc3")
+	keys := testKeyring(t)
+
+	s, err := keys.MakeScopedJWT(id, "client", []string{ScopeChirpsRead, ScopeChirpsWrite}, time.Minute)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	token, err := keys.ParseAccessToken(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if token.ClientID != "client" || len(token.Scopes) != 2 || token.Scopes[1] != ScopeChirpsWrite {
+		t.Errorf("Unexpected client or scopes: %v %v", token.ClientID, token.Scopes)
+	}
+
+	s, err = keys.MakeJWT(id, time.Minute)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	token, err = keys.ParseAccessToken(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if token.ClientID != "" || token.Scopes != nil {
+		t.Errorf("Login tokens shouldn't be scoped: %v %v", token.ClientID, token.Scopes)
+	}
+
+	_, err = keys.MakeScopedJWT(id, "client", nil, time.Minute)
+	if err == nil {
+		t.Errorf("Scoped tokens without scopes should be refused")
+	}
+}
+
+func TestJWTRole(t *testing.T) {
+	id := uuid.MustParse("253be0c3-c9e8-4d34-b6a9-9a8211884bc3")
+	keys := testKeyring(t)
+
+	for _, role := range []string{RoleModerator, RoleAdmin} {
+		s, err := keys.MakeJWTWithRole(id, role, time.Minute)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		token, err := keys.ParseAccessToken(s)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if token.Role != role {
+			t.Errorf("Expected role %v but got %q", role, token.Role)
+		}
+	}
+
+	s, err := keys.MakeJWT(id, time.Minute)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	token, err := keys.ParseAccessToken(s)
+	if err != nil || token.Role != "" {
+		t.Errorf("Plain users shouldn't get a role claim: %q %v", token.Role, err)
+	}
+
+	_, err = keys.MakeJWTWithRole(id, "superuser", time.Minute)
+	if err == nil {
+		t.Errorf("Unknown roles should be refused")
+	}
+
+	forged, err := keys.Sign(tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    keys.Issuer,
+			Audience:  jwt.ClaimStrings{keys.Audience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			Subject:   id.String(),
+			ID:        uuid.NewString(),
+		},
+		Scope:    ScopeChirpsRead,
+		ClientID: "client",
+		Role:     RoleAdmin,
+	})
+	if err != nil {
+		t.Fatalf("Test is broken: %v", err)
+	}
+
+	_, err = keys.ParseAccessToken(forged)
+	if err == nil {
+		t.Errorf("Tokens issued to clients shouldn't carry a role")
+	}
+}
@@ -0,0 +1,423 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// A key tokens are signed or verified with
+// Keys without a private half can only verify
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+
+	signingKey any
+	verifyKey  any
+}
+
+func (k *SigningKey) CanSign() bool {
+	return k.signingKey != nil
+}
+
+// Symmetric keys are never published in the JWKS
+func (k *SigningKey) IsSymmetric() bool {
+	return k.Method == jwt.SigningMethodHS256
+}
+
+func NewHMACKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{
+		ID:         id,
+		Method:     jwt.SigningMethodHS256,
+		signingKey: secret,
+		verifyKey:  secret,
+	}
+}
+
+func NewEd25519Key(id string, private ed25519.PrivateKey) *SigningKey {
+	return &SigningKey{
+		ID:         id,
+		Method:     jwt.SigningMethodEdDSA,
+		signingKey: private,
+		verifyKey:  private.Public(),
+	}
+}
+
+func NewRSAKey(id string, private *rsa.PrivateKey) *SigningKey {
+	return &SigningKey{
+		ID:         id,
+		Method:     jwt.SigningMethodRS256,
+		signingKey: private,
+		verifyKey:  &private.PublicKey,
+	}
+}
+
+// Decodes a JWT_SECRET style base64 secret; the key ID is derived from the
+// secret so it stays the same across restarts
+func ParseHMACSecret(secret string) (*SigningKey, error) {
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode secret: %w", err)
+	}
+
+	sum := sha256.Sum256(key)
+	return NewHMACKey("hs-"+hex.EncodeToString(sum[:8]), key), nil
+}
+
+// Parses a PEM encoded Ed25519 or RSA key
+// Private keys can sign, public keys can only verify
+// The key ID is the RFC 7638 thumbprint of the public key unless id is set
+func ParseKeyPEM(id string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data found")
+	}
+
+	var parsed any
+	var err error
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("Unsupported PEM block: %v", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %v: %w", block.Type, err)
+	}
+
+	var key *SigningKey
+
+	switch k := parsed.(type) {
+	case ed25519.PrivateKey:
+		key = NewEd25519Key(id, k)
+	case *rsa.PrivateKey:
+		key = NewRSAKey(id, k)
+	case ed25519.PublicKey:
+		key = &SigningKey{ID: id, Method: jwt.SigningMethodEdDSA, verifyKey: k}
+	case *rsa.PublicKey:
+		key = &SigningKey{ID: id, Method: jwt.SigningMethodRS256, verifyKey: k}
+	default:
+		return nil, fmt.Errorf("Unsupported key type: %T", parsed)
+	}
+
+	if len(key.ID) == 0 {
+		key.ID, err = key.thumbprint()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+func LoadKeyFile(id, path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read key: %w", err)
+	}
+
+	key, err := ParseKeyPEM(id, data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load key from %v: %w", path, err)
+	}
+	return key, nil
+}
+
+// Loads a key given as either a path or kid=path, so a key that was signing
+// with a custom key ID keeps it once it is retired
+func LoadKeyFileSpec(spec string) (*SigningKey, error) {
+	id, path, found := strings.Cut(spec, "=")
+	if !found {
+		return LoadKeyFile("", spec)
+	}
+	if id == "" || path == "" {
+		return nil, fmt.Errorf("Invalid key file: %v", spec)
+	}
+	return LoadKeyFile(id, path)
+}
+
+// A public key in RFC 7517 JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (k *SigningKey) JWK() (JWK, error) {
+	jwk := JWK{
+		KeyID:     k.ID,
+		Use:       "sig",
+		Algorithm: k.Method.Alg(),
+	}
+
+	switch public := k.verifyKey.(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	default:
+		return jwk, fmt.Errorf("Key %v has no public form", k.ID)
+	}
+
+	return jwk, nil
+}
+
+// Parses a public key published by another service, which can only verify
+// Only the key types Chirpy publishes itself are supported
+func ParseJWK(jwk JWK) (*SigningKey, error) {
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return nil, fmt.Errorf("Key %v is not for signatures", jwk.KeyID)
+	}
+
+	key := &SigningKey{ID: jwk.KeyID}
+
+	switch jwk.KeyType {
+	case "OKP":
+		if jwk.Curve != "Ed25519" {
+			return nil, fmt.Errorf("Unsupported curve: %v", jwk.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Invalid Ed25519 key %v", jwk.KeyID)
+		}
+		key.Method = jwt.SigningMethodEdDSA
+		key.verifyKey = ed25519.PublicKey(x)
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid RSA modulus in key %v: %w", jwk.KeyID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("Invalid RSA exponent in key %v", jwk.KeyID)
+		}
+		public := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if public.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key %v is too small", jwk.KeyID)
+		}
+		key.Method = jwt.SigningMethodRS256
+		key.verifyKey = public
+	default:
+		return nil, fmt.Errorf("Unsupported key type: %v", jwk.KeyType)
+	}
+
+	if jwk.Algorithm != "" && jwk.Algorithm != key.Method.Alg() {
+		return nil, fmt.Errorf("Unsupported algorithm %v for key %v", jwk.Algorithm, jwk.KeyID)
+	}
+
+	return key, nil
+}
+
+// RFC 7638: SHA-256 over the required members in lexicographic order
+func (k *SigningKey) thumbprint() (string, error) {
+	jwk, err := k.JWK()
+	if err != nil {
+		return "", err
+	}
+
+	var members any
+	switch jwk.KeyType {
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// Keys tokens are signed and verified with
+// New tokens are signed with the primary key; retired keys stay around so
+// tokens they signed remain valid until they expire
+type Keyring struct {
+	mu      sync.RWMutex
+	primary *SigningKey
+	keys    map[string]*SigningKey
+	// Verifies tokens without a kid header, issued before key IDs existed
+	legacy *SigningKey
+
+	// Set on issued tokens and required when validating them
+	Issuer   string
+	Audience string
+	// Allowed clock difference with other servers when checking times
+	Leeway time.Duration
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys:     map[string]*SigningKey{},
+		Issuer:   DefaultIssuer,
+		Audience: DefaultAudience,
+		Leeway:   DefaultLeeway,
+	}
+}
+
+// Adds a key for verification; the last key added with primary set signs
+// new tokens
+func (k *Keyring) Add(key *SigningKey, primary bool) error {
+	if primary && !key.CanSign() {
+		return fmt.Errorf("Key %v cannot sign", key.ID)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	_, exists := k.keys[key.ID]
+	if exists {
+		return fmt.Errorf("Duplicate key ID: %v", key.ID)
+	}
+
+	k.keys[key.ID] = key
+	if primary {
+		k.primary = key
+	}
+	return nil
+}
+
+func (k *Keyring) SetLegacyKey(key *SigningKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.legacy = key
+}
+
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	key := k.primary
+	k.mu.RUnlock()
+
+	if key == nil {
+		return "", fmt.Errorf("No signing key")
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	if len(key.ID) > 0 {
+		token.Header["kid"] = key.ID
+	}
+
+	return token.SignedString(key.signingKey)
+}
+
+// Parses and verifies a token with the key named by its kid header
+// The token must use the algorithm of that key, so a public key can never be
+// used as an HMAC secret
+func (k *Keyring) Parse(tokenString string, claims jwt.Claims, options ...jwt.ParserOption) (*jwt.Token, error) {
+	keyFunc := func(token *jwt.Token) (any, error) {
+		k.mu.RLock()
+		defer k.mu.RUnlock()
+
+		key := k.legacy
+
+		kid, ok := token.Header["kid"]
+		if ok {
+			id, ok := kid.(string)
+			if !ok {
+				return nil, fmt.Errorf("Invalid kid header: %v", kid)
+			}
+			key = k.keys[id]
+		}
+
+		if key == nil {
+			return nil, fmt.Errorf("Unknown signing key: %v", kid)
+		}
+
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("Token algorithm %v does not match key %v",
+				token.Method.Alg(), key.ID)
+		}
+
+		return key.verifyKey, nil
+	}
+
+	options = append(options, jwt.WithValidMethods(k.methods()))
+	return jwt.ParseWithClaims(tokenString, claims, keyFunc, options...)
+}
+
+// The algorithms of the keys in the ring; tokens using any other algorithm
+// are rejected before a key is looked up
+func (k *Keyring) methods() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	methods := []string{}
+	seen := map[string]bool{}
+	for _, key := range k.keys {
+		alg := key.Method.Alg()
+		if !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+	if k.legacy != nil && !seen[k.legacy.Method.Alg()] {
+		methods = append(methods, k.legacy.Method.Alg())
+	}
+	return methods
+}
+
+// The public keys, for other services to verify tokens with
+func (k *Keyring) JWKS() JWKSet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+
+	for _, key := range k.keys {
+		if key.IsSymmetric() {
+			continue
+		}
+		jwk, err := key.JWK()
+		if err != nil {
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var keyringUserID = uuid.MustParse("253be0c3-c9e8-4d34-b6a9-9a8211884bc3")
+
+func newEd25519Key(t *testing.T, id string) *SigningKey {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Test is broken: %v", err)
+	}
+	return NewEd25519Key(id, private)
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Test is broken: %v", err)
+	}
+
+	hmacKey, err := ParseHMACSecret(key)
+	if err != nil {
+		t.Fatalf("Test is broken: %v", err)
+	}
+
+	for _, k := range []*SigningKey{
+		hmacKey,
+		newEd25519Key(t, "ed"),
+		NewRSAKey("rsa", rsaKey),
+	} {
+		keys := NewKeyring()
+		err := keys.Add(k, true)
+		if err != nil {
+			t.Errorf("%v: %v", k.Method.Alg(), err)
+			continue
+		}
+
+		s, err := keys.MakeJWT(keyringUserID, time.Minute)
+		if err != nil {
+			t.Errorf("%v: %v", k.Method.Alg(), err)
+			continue
+		}
+
+		id, err := keys.ValidateJWT(s)
+		if err != nil {
+			t.Errorf("%v: %v", k.Method.Alg(), err)
+			continue
+		}
+		if id != keyringUserID {
+			t.Errorf("%v: wrong ID: %v != %v", k.Method.Alg(), id, keyringUserID)
+		}
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	old := newEd25519Key(t, "old")
+	current := newEd25519Key(t, "current")
+
+	keys := NewKeyring()
+	keys.Add(old, true)
+
+	oldToken, err := keys.MakeJWT(keyringUserID, time.Minute)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	keys.Add(current, true)
+
+	newToken, err := keys.MakeJWT(keyringUserID, time.Minute)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, s := range []string{oldToken, newToken} {
+		_, err = keys.ValidateJWT(s)
+		if err != nil {
+			t.Errorf("Retired and primary keys should verify: %v", err)
+		}
+	}
+
+	other := NewKeyring()
+	other.Add(current, true)
+
+	_, err = other.ValidateJWT(oldToken)
+	if err == nil {
+		t.Errorf("Token signed by an unknown key should not verify")
+	}
+}
+
+func TestKeyringRejectsAlgorithmMismatch(t *testing.T) {
+	ed := newEd25519Key(t, "shared")
+
+	// An HMAC key that happens to share the kid of an Ed25519 key
+	forger := NewKeyring()
+	forger.Add(NewHMACKey("shared", ed.verifyKey.(ed25519.PublicKey)), true)
+
+	s, err := forger.MakeJWT(keyringUserID, time.Minute)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	keys := NewKeyring()
+	keys.Add(ed, true)
+
+	_, err = keys.ValidateJWT(s)
+	if err == nil {
+		t.Errorf("HS256 token should not verify against an EdDSA key")
+	}
+}
+
+func TestKeyringLegacyTokens(t *testing.T) {
+	hmacKey, err := ParseHMACSecret(key)
+	if err != nil {
+		t.Fatalf("Test is broken: %v", err)
+	}
+
+	// Signed without a kid header, as tokens were before key IDs
+	legacy := NewKeyring()
+	legacy.Add(NewHMACKey("", hmacKey.signingKey.([]byte)), true)
+
+	s, err := legacy.MakeJWT(keyringUserID, time.Minute)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	keys := NewKeyring()
+	keys.Add(newEd25519Key(t, "ed"), true)
+
+	_, err = keys.ValidateJWT(s)
+	if err == nil {
+		t.Errorf("Token without kid should not verify without a legacy key")
+	}
+
+	keys.SetLegacyKey(hmacKey)
+
+	_, err = keys.ValidateJWT(s)
+	if err != nil {
+		t.Errorf("Token without kid should verify with the legacy key: %v", err)
+	}
+}
+
+func TestParseKeyPEM(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Test is broken: %v", err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("Test is broken: %v", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatalf("Test is broken: %v", err)
+	}
+
+	signing, err := ParseKeyPEM("", pem.EncodeToMemory(
+		&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	verifying, err := ParseKeyPEM("", pem.EncodeToMemory(
+		&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !signing.CanSign() || verifying.CanSign() {
+		t.Errorf("Only private keys should sign")
+	}
+
+	if signing.ID != verifying.ID || len(signing.ID) == 0 {
+		t.Errorf("Both halves should share a thumbprint: %v != %v",
+			signing.ID, verifying.ID)
+	}
+
+	keys := NewKeyring()
+	err = keys.Add(verifying, true)
+	if err == nil {
+		t.Errorf("Public key should not become the primary key")
+	}
+}
+
+func TestLoadKeyFileSpec(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Test is broken: %v", err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("Test is broken: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	err = os.WriteFile(path, pem.EncodeToMemory(
+		&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600)
+	if err != nil {
+		t.Fatalf("Test is broken: %v", err)
+	}
+
+	named, err := LoadKeyFileSpec("custom=" + path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if named.ID != "custom" {
+		t.Errorf("Expected kid custom, got %v", named.ID)
+	}
+
+	unnamed, err := LoadKeyFileSpec(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	thumbprint, err := unnamed.thumbprint()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if unnamed.ID != thumbprint {
+		t.Errorf("Expected the thumbprint %v, got %v", thumbprint, unnamed.ID)
+	}
+
+	_, err = LoadKeyFileSpec("=" + path)
+	if err == nil {
+		t.Errorf("An empty kid should be rejected")
+	}
+}
+
+func TestKeyringJWKS(t *testing.T) {
+	hmacKey, err := ParseHMACSecret(key)
+	if err != nil {
+		t.Fatalf("Test is broken: %v", err)
+	}
+
+	keys := NewKeyring()
+	keys.Add(hmacKey, false)
+	keys.Add(newEd25519Key(t, "ed"), true)
+
+	set := keys.JWKS()
+	if len(set.Keys) != 1 {
+		t.Fatalf("Expected only the public key but got %v", set.Keys)
+	}
+
+	jwk := set.Keys[0]
+	if jwk.KeyID != "ed" || jwk.KeyType != "OKP" || jwk.Algorithm != "EdDSA" || len(jwk.X) == 0 {
+		t.Errorf("Unexpected JWK: %+v", jwk)
+	}
+}
+
+func TestParseJWK(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Test is broken: %v", err)
+	}
+
+	for _, k := range []*SigningKey{
+		newEd25519Key(t, "ed"),
+		NewRSAKey("rsa", rsaKey),
+	} {
+		signing := NewKeyring()
+		signing.Add(k, true)
+
+		s, err := signing.MakeJWT(keyringUserID, time.Minute)
+		if err != nil {
+			t.Fatalf("Test is broken: %v", err)
+		}
+
+		jwk, err := k.JWK()
+		if err != nil {
+			t.Fatalf("Test is broken: %v", err)
+		}
+
+		public, err := ParseJWK(jwk)
+		if err != nil {
+			t.Errorf("%v: %v", k.Method.Alg(), err)
+			continue
+		}
+		if public.CanSign() {
+			t.Errorf("%v: key from a JWK should only verify", k.Method.Alg())
+		}
+
+		verifying := NewKeyring()
+		verifying.Add(public, false)
+
+		id, err := verifying.ValidateJWT(s)
+		if err != nil || id != keyringUserID {
+			t.Errorf("%v: token should verify with the parsed key: %v", k.Method.Alg(), err)
+		}
+	}
+
+	jwk, _ := newEd25519Key(t, "ed").JWK()
+	jwk.Algorithm = "RS256"
+	_, err = ParseJWK(jwk)
+	if err == nil {
+		t.Errorf("Key with a mismatched algorithm should be rejected")
+	}
+
+	jwk.Algorithm = ""
+	jwk.Use = "enc"
+	_, err = ParseJWK(jwk)
+	if err == nil {
+		t.Errorf("Encryption key should be rejected")
+	}
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+)
+
+// RFC 7636 section 4.1
+var pkceVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// Only S256 is supported; plain challenges would give nothing away to an
+// attacker who intercepts the authorization request
+const PKCEMethodS256 = "S256"
+
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Checks the code verifier sent to the token endpoint against the challenge
+// sent with the authorization request
+func VerifyPKCE(verifier, challenge string) error {
+	if !pkceVerifierPattern.MatchString(verifier) {
+		return fmt.Errorf("Invalid code verifier")
+	}
+
+	expected := PKCEChallenge(verifier)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) != 1 {
+		return fmt.Errorf("Code verifier doesn't match the challenge")
+	}
+
+	return nil
+}
+
+// A challenge is a base64url encoded SHA-256 hash
+func ValidatePKCEChallenge(challenge, method string) error {
+	if method != PKCEMethodS256 {
+		return fmt.Errorf("Unsupported code challenge method: %q", method)
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(challenge)
+	if err != nil || len(decoded) != sha256.Size {
+		return fmt.Errorf("Invalid code challenge")
+	}
+
+	return nil
+}
+
+// Redirect URIs must be absolute and use https, except on the loopback
+// interface where native apps listen (RFC 8252 section 7.3)
+// Fragments aren't allowed since the code is added to the query
+func ValidateRedirectURI(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("Invalid redirect URI: %w", err)
+	}
+
+	if !u.IsAbs() || len(u.Host) == 0 {
+		return fmt.Errorf("Redirect URI must be absolute: %v", s)
+	}
+
+	if len(u.Fragment) > 0 {
+		return fmt.Errorf("Redirect URI must not have a fragment: %v", s)
+	}
+
+	if u.Scheme == "https" {
+		return nil
+	}
+
+	ip := net.ParseIP(u.Hostname())
+	if u.Scheme == "http" && (u.Hostname() == "localhost" || (ip != nil && ip.IsLoopback())) {
+		return nil
+	}
+
+	return fmt.Errorf("Redirect URI must use https: %v", s)
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	// RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if PKCEChallenge(verifier) != challenge {
+		t.Errorf("Expected challenge %v but got %v", challenge, PKCEChallenge(verifier))
+	}
+
+	err := ValidatePKCEChallenge(challenge, PKCEMethodS256)
+	if err != nil {
+		t.Errorf("Challenge should be valid: %v", err)
+	}
+
+	err = VerifyPKCE(verifier, challenge)
+	if err != nil {
+		t.Errorf("Verifier should match: %v", err)
+	}
+
+	err = VerifyPKCE("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXX", challenge)
+	if err == nil {
+		t.Errorf("Wrong verifier should not match")
+	}
+
+	err = VerifyPKCE("short", PKCEChallenge("short"))
+	if err == nil {
+		t.Errorf("Short verifier should be rejected")
+	}
+
+	err = ValidatePKCEChallenge(verifier, "plain")
+	if err == nil {
+		t.Errorf("Plain challenges should be rejected")
+	}
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	valid := []string{
+		"https://app.example.com/callback",
+		"https://app.example.com/callback?x=1",
+		"http://localhost:8000/callback",
+		"http://127.0.0.1:51234/",
+		"http://[::1]/callback",
+	}
+	for _, uri := range valid {
+		err := ValidateRedirectURI(uri)
+		if err != nil {
+			t.Errorf("%v should be valid: %v", uri, err)
+		}
+	}
+
+	invalid := []string{
+		"http://app.example.com/callback",
+		"https://app.example.com/callback#fragment",
+		"/callback",
+		"javascript:alert(1)",
+	}
+	for _, uri := range invalid {
+		err := ValidateRedirectURI(uri)
+		if err == nil {
+			t.Errorf("%v should be invalid", uri)
+		}
+	}
+}
@@ -1,28 +1,108 @@
 package auth
 
 import (
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/alexedwards/argon2id"
 )
 
+// Argon2id settings new hashes are made with
+type PasswordParams = argon2id.Params
+
+// 64MiB per hash, in line with the OWASP recommendations
+var DefaultPasswordParams = PasswordParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var passwordParams = struct {
+	mu     sync.RWMutex
+	params PasswordParams
+}{params: DefaultPasswordParams}
+
+func ValidatePasswordParams(p PasswordParams) error {
+	if p.Memory < 8*uint32(p.Parallelism) {
+		return fmt.Errorf("Memory must be at least 8KiB per thread")
+	}
+	if p.Iterations < 1 || p.Parallelism < 1 {
+		return fmt.Errorf("Iterations and parallelism must be at least 1")
+	}
+	if p.SaltLength < 16 || p.KeyLength < 16 {
+		return fmt.Errorf("Salt and key must be at least 16 bytes")
+	}
+	return nil
+}
+
+// Changes the parameters new hashes are made with
+// Existing hashes still verify and are reported as needing a rehash
+func SetPasswordParams(p PasswordParams) error {
+	err := ValidatePasswordParams(p)
+	if err != nil {
+		return err
+	}
+
+	passwordParams.mu.Lock()
+	defer passwordParams.mu.Unlock()
+	passwordParams.params = p
+	return nil
+}
+
+func CurrentPasswordParams() PasswordParams {
+	passwordParams.mu.RLock()
+	defer passwordParams.mu.RUnlock()
+	return passwordParams.params
+}
+
+// How long one hash takes with the given parameters, to check at startup
+// that they suit the machine
+func TimePasswordHash(p PasswordParams) (time.Duration, error) {
+	start := time.Now()
+	_, err := argon2id.CreateHash("chirpy benchmark password", &p)
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
 func HashPassword(password string) (string, error) {
-	hash, err := argon2id.CreateHash(password,
-		&argon2id.Params{
-			Memory:      800000,
-			Iterations:  1,
-			Parallelism: 1,
-			SaltLength:  16,
-			KeyLength:   16,
-		})
+	params := CurrentPasswordParams()
+	hash, err := argon2id.CreateHash(password, &params)
 	if err != nil {
 		return "", err
 	}
 	return hash, nil
 }
 
-func CheckPasswordHash(password, hash string) (bool, error) {
-	matches, err := argon2id.ComparePasswordAndHash(password, hash)
+// Also reports whether the hash was made with parameters other than the
+// current ones, in which case it should be replaced with a fresh hash while
+// the password is at hand
+func CheckPasswordHash(password, hash string) (matches bool, needsRehash bool, err error) {
+	matches, params, err := argon2id.CheckHash(password, hash)
 	if err != nil {
-		return false, err
+		return false, false, err
+	}
+	return matches, *params != CurrentPasswordParams(), nil
+}
+
+var dummyHash struct {
+	once sync.Once
+	hash string
+	err  error
+}
+
+// Takes as long as checking a real password; used when there is no user so
+// response times don't reveal which emails have accounts
+func CheckDummyPassword(password string) {
+	dummyHash.once.Do(func() {
+		dummyHash.hash, dummyHash.err = HashPassword("chirpy dummy password")
+	})
+	if dummyHash.err != nil {
+		return
 	}
-	return matches, nil
+	CheckPasswordHash(password, dummyHash.hash)
 }
@@ -19,7 +19,7 @@ func TestHashPassword(t *testing.T) {
 func TestCheckPasswordHash(t *testing.T) {
 	h := "$argon2id$v=19$m=800000,t=1,p=1$/bxE+IPZO0qLotrvoNoS2g$wQb9oQQXCveFl2gDHqfi6A"
 
-	matches, err := CheckPasswordHash("pa$$word", h)
+	matches, needsRehash, err := CheckPasswordHash("pa$$word", h)
 	if err != nil {
 		t.Errorf("Got error: %v", err)
 		return
@@ -29,7 +29,11 @@ func TestCheckPasswordHash(t *testing.T) {
 		t.Errorf("Password hash doesn't match: '%v'", h)
 	}
 
-	matches, err = CheckPasswordHash("p4$$word", h)
+	if !needsRehash {
+		t.Errorf("Hash with old parameters should need a rehash: '%v'", h)
+	}
+
+	matches, _, err = CheckPasswordHash("p4$$word", h)
 	if err != nil {
 		t.Errorf("Got error: %v", err)
 		return
@@ -39,3 +43,44 @@ func TestCheckPasswordHash(t *testing.T) {
 		t.Errorf("Password hash matches: '%v'", h)
 	}
 }
+
+func TestPasswordParams(t *testing.T) {
+	defer SetPasswordParams(DefaultPasswordParams)
+
+	h, err := HashPassword(p)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	_, needsRehash, err := CheckPasswordHash(p, h)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if needsRehash {
+		t.Errorf("Hash with current parameters shouldn't need a rehash")
+	}
+
+	params := DefaultPasswordParams
+	params.Iterations++
+	err = SetPasswordParams(params)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	matches, needsRehash, err := CheckPasswordHash(p, h)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !matches {
+		t.Errorf("Old hash should still match")
+	}
+	if !needsRehash {
+		t.Errorf("Hash with old parameters should need a rehash")
+	}
+
+	params.Parallelism = 0
+	err = SetPasswordParams(params)
+	if err == nil {
+		t.Errorf("Invalid parameters should be rejected")
+	}
+}
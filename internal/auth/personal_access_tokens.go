@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Personal access tokens carry this prefix so they can be told apart from
+// JWTs, and spotted by secret scanners
+const PersonalAccessTokenPrefix = "chirpy_pat_"
+
+// What a personal access token may be used for
+// Logins aren't limited by scope
+const (
+	ScopeChirpsRead   = "chirps:read"
+	ScopeChirpsWrite  = "chirps:write"
+	ScopeProfileWrite = "profile:write"
+)
+
+var Scopes = []string{ScopeChirpsRead, ScopeChirpsWrite, ScopeProfileWrite}
+
+func MakePersonalAccessToken() (string, error) {
+	bytes := make([]byte, 32)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	return PersonalAccessTokenPrefix + hex.EncodeToString(bytes), nil
+}
+
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
+
+// Checks requested scopes are known and returns them sorted without
+// duplicates
+func ParseScopes(scopes []string) ([]string, error) {
+	result := []string{}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return nil, fmt.Errorf("Unknown scope: %v", scope)
+		}
+		if !slices.Contains(result, scope) {
+			result = append(result, scope)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("At least one scope is required")
+	}
+
+	slices.Sort(result)
+	return result, nil
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMakePersonalAccessToken(t *testing.T) {
+	token, err := MakePersonalAccessToken()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !IsPersonalAccessToken(token) {
+		t.Errorf("Token should have the personal access token prefix: %v", token)
+	}
+
+	jwt, err := MakeJWT(uuid.New(), key, time.Hour)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if IsPersonalAccessToken(jwt) {
+		t.Errorf("A JWT shouldn't look like a personal access token")
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes([]string{ScopeChirpsWrite, ScopeChirpsRead, ScopeChirpsWrite})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := []string{ScopeChirpsRead, ScopeChirpsWrite}
+	if !slices.Equal(scopes, expected) {
+		t.Errorf("Expected %v but got %v", expected, scopes)
+	}
+
+	_, err = ParseScopes([]string{"admin"})
+	if err == nil {
+		t.Errorf("Unknown scopes should be rejected")
+	}
+
+	_, err = ParseScopes([]string{})
+	if err == nil {
+		t.Errorf("No scopes should be rejected")
+	}
+}
@@ -0,0 +1,39 @@
+package auth
+
+import "fmt"
+
+// Roles in increasing order of power; each can do everything the ones
+// before it can
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+func ParseRole(role string) (string, error) {
+	if roleRank(role) < 0 {
+		return "", fmt.Errorf("Unknown role: %v", role)
+	}
+	return role, nil
+}
+
+// Whether role grants what required does; tokens without a role claim are
+// plain users
+func HasRole(role, required string) bool {
+	if role == "" {
+		role = RoleUser
+	}
+	rank, requiredRank := roleRank(role), roleRank(required)
+	return rank >= 0 && requiredRank >= 0 && rank >= requiredRank
+}
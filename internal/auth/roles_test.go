@@ -0,0 +1,41 @@
+package auth
+
+import "testing"
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		role     string
+		required string
+		expected bool
+	}{
+		{RoleAdmin, RoleAdmin, true},
+		{RoleAdmin, RoleModerator, true},
+		{RoleModerator, RoleModerator, true},
+		{RoleModerator, RoleAdmin, false},
+		{RoleUser, RoleModerator, false},
+		{"", RoleUser, true},
+		{"", RoleModerator, false},
+		{"superuser", RoleUser, false},
+		{RoleAdmin, "superuser", false},
+	}
+
+	for _, tt := range tests {
+		if HasRole(tt.role, tt.required) != tt.expected {
+			t.Errorf("HasRole(%q, %q) should be %v", tt.role, tt.required, tt.expected)
+		}
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for _, role := range Roles {
+		parsed, err := ParseRole(role)
+		if err != nil || parsed != role {
+			t.Errorf("%v: %v", role, err)
+		}
+	}
+
+	_, err := ParseRole("Admin")
+	if err == nil {
+		t.Errorf("Roles should be case sensitive")
+	}
+}
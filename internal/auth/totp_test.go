@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// The SHA-1 secret from the RFC 6238 test vectors, base32 encoded
+var rfcSecret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to 6 digits
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, v := range vectors {
+		code, err := TOTPCode(rfcSecret, time.Unix(v.unix, 0))
+		if err != nil {
+			t.Errorf("%v: %v", v.unix, err)
+			continue
+		}
+		if code != v.code {
+			t.Errorf("%v: expected %v but got %v", v.unix, v.code, code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step := TOTPStep(now)
+
+	step2, err := ValidateTOTP(rfcSecret, "081804", now, 0)
+	if err != nil {
+		t.Errorf("Current code should validate: %v", err)
+	}
+	if step2 != step {
+		t.Errorf("Expected step %v but got %v", step, step2)
+	}
+
+	// The previous period's code is still accepted
+	_, err = ValidateTOTP(rfcSecret, "081804", now.Add(TOTPPeriod), 0)
+	if err != nil {
+		t.Errorf("Code within skew should validate: %v", err)
+	}
+
+	_, err = ValidateTOTP(rfcSecret, "081804", now.Add(3*TOTPPeriod), 0)
+	if err == nil {
+		t.Errorf("Code outside skew should not validate")
+	}
+
+	_, err = ValidateTOTP(rfcSecret, "081804", now, step)
+	if err == nil {
+		t.Errorf("Code should not validate twice")
+	}
+
+	_, err = ValidateTOTP(rfcSecret, "000000", now, 0)
+	if err == nil {
+		t.Errorf("Wrong code should not validate")
+	}
+
+	_, err = ValidateTOTP(rfcSecret, "0818", now, 0)
+	if err == nil {
+		t.Errorf("Short code should not validate")
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	now := time.Now()
+	code, err := TOTPCode(secret, now)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	_, err = ValidateTOTP(secret, code, now, 0)
+	if err != nil {
+		t.Errorf("Generated secret should round trip: %v", err)
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	u, err := url.Parse(TOTPURI("Chirpy", "user@example.com", rfcSecret))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Chirpy:user@example.com" {
+		t.Errorf("Unexpected URI: %v", u)
+	}
+
+	if u.Query().Get("secret") != rfcSecret || u.Query().Get("issuer") != "Chirpy" {
+		t.Errorf("Unexpected query: %v", u.RawQuery)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("Unexpected format: %v", code)
+		}
+		if seen[code] {
+			t.Errorf("Duplicate code: %v", code)
+		}
+		seen[code] = true
+	}
+
+	if HashRecoveryCode("abcde-fghij") != HashRecoveryCode("ABCDEFGHIJ") {
+		t.Errorf("Hash should ignore case and separators")
+	}
+}
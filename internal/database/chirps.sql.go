@@ -7,29 +7,37 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to,
+    rechirp_of)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3,
+    $4
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, search_vector, in_reply_to, deleted_at, rechirp_of, hidden_at
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body      string
+	UserID    uuid.UUID
+	InReplyTo uuid.NullUUID
+	RechirpOf uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID, arg.InReplyTo, arg.RechirpOf)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -37,6 +45,11 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.SearchVector,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.HiddenAt,
 	)
 	return i, err
 }
@@ -44,7 +57,7 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 const deleteChirpByID = `-- name: DeleteChirpByID :one
 DELETE FROM chirps *
 WHERE id = $1 AND user_id = $2
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, search_vector, in_reply_to, deleted_at, rechirp_of, hidden_at
 `
 
 type DeleteChirpByIDParams struct {
@@ -61,16 +74,43 @@ func (q *Queries) DeleteChirpByID(ctx context.Context, arg DeleteChirpByIDParams
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.SearchVector,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.HiddenAt,
+	)
+	return i, err
+}
+
+const getChirpByID = `-- name: GetChirpByID :one
+SELECT id, created_at, updated_at, body, user_id, search_vector, in_reply_to, deleted_at, rechirp_of, hidden_at FROM chirps WHERE id = $1
+`
+
+func (q *Queries) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpByID, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.SearchVector,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.HiddenAt,
 	)
 	return i, err
 }
 
-const getAllChirps = `-- name: GetAllChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps ORDER BY created_at ASC
+const getChirpsByIDs = `-- name: GetChirpsByIDs :many
+SELECT id, created_at, updated_at, body, user_id, search_vector, in_reply_to, deleted_at, rechirp_of, hidden_at FROM chirps WHERE id = ANY($1::uuid[])
 `
 
-func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirps)
+func (q *Queries) GetChirpsByIDs(ctx context.Context, ids []uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByIDs, pq.Array(ids))
 	if err != nil {
 		return nil, err
 	}
@@ -84,6 +124,11 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.SearchVector,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.RechirpOf,
+			&i.HiddenAt,
 		); err != nil {
 			return nil, err
 		}
@@ -98,12 +143,15 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getChirpByID = `-- name: GetChirpByID :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE id = $1
+const hideChirp = `-- name: HideChirp :one
+UPDATE chirps
+SET hidden_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING id, created_at, updated_at, body, user_id, search_vector, in_reply_to, deleted_at, rechirp_of, hidden_at
 `
 
-func (q *Queries) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, getChirpByID, id)
+func (q *Queries) HideChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, hideChirp, id)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -111,16 +159,89 @@ func (q *Queries) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error)
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.SearchVector,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.HiddenAt,
 	)
 	return i, err
 }
 
-const getChirpsByAuthorID = `-- name: GetChirpsByAuthorID :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE user_id = $1 ORDER BY created_at ASC
+const listChirpsAfter = `-- name: ListChirpsAfter :many
+SELECT id, created_at, updated_at, body, user_id, search_vector, in_reply_to, deleted_at, rechirp_of, hidden_at FROM chirps
+WHERE deleted_at IS NULL
+AND ($1::uuid IS NULL OR user_id = $1)
+AND (hidden_at IS NULL OR user_id = $2)
+AND ($3::timestamp IS NULL
+    OR (created_at, id) > ($3, $4::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT $5
+`
+
+type ListChirpsAfterParams struct {
+	AuthorID        uuid.NullUUID
+	ViewerID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	Limit           int32
+}
+
+func (q *Queries) ListChirpsAfter(ctx context.Context, arg ListChirpsAfterParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsAfter, arg.AuthorID, arg.ViewerID, arg.CursorCreatedAt, arg.CursorID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.SearchVector,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.RechirpOf,
+			&i.HiddenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpsBefore = `-- name: ListChirpsBefore :many
+SELECT id, created_at, updated_at, body, user_id, search_vector, in_reply_to, deleted_at, rechirp_of, hidden_at FROM chirps
+WHERE deleted_at IS NULL
+AND ($1::uuid IS NULL OR user_id = $1)
+AND (hidden_at IS NULL OR user_id = $2)
+AND ($3::timestamp IS NULL
+    OR (created_at, id) < ($3, $4::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT $5
 `
 
-func (q *Queries) GetChirpsByAuthorID(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsByAuthorID, userID)
+type ListChirpsBeforeParams struct {
+	AuthorID        uuid.NullUUID
+	ViewerID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	Limit           int32
+}
+
+func (q *Queries) ListChirpsBefore(ctx context.Context, arg ListChirpsBeforeParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsBefore, arg.AuthorID, arg.ViewerID, arg.CursorCreatedAt, arg.CursorID, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -134,6 +255,11 @@ func (q *Queries) GetChirpsByAuthorID(ctx context.Context, userID uuid.UUID) ([]
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.SearchVector,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.RechirpOf,
+			&i.HiddenAt,
 		); err != nil {
 			return nil, err
 		}
@@ -148,9 +274,106 @@ func (q *Queries) GetChirpsByAuthorID(ctx context.Context, userID uuid.UUID) ([]
 	return items, nil
 }
 
+const listUserChirps = `-- name: ListUserChirps :many
+SELECT id, created_at, updated_at, body, user_id, search_vector, in_reply_to, deleted_at, rechirp_of, hidden_at
+FROM chirps
+WHERE user_id = $1
+ORDER BY created_at, id
+`
+
+// Every chirp a user has made, including hidden ones and tombstones
+func (q *Queries) ListUserChirps(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listUserChirps, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.SearchVector,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.RechirpOf,
+			&i.HiddenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markChirpDeleted = `-- name: MarkChirpDeleted :one
+UPDATE chirps
+SET updated_at = NOW(), deleted_at = NOW(), body = ''
+WHERE id = $1 AND user_id = $2
+RETURNING id, created_at, updated_at, body, user_id, search_vector, in_reply_to, deleted_at, rechirp_of, hidden_at
+`
+
+type MarkChirpDeletedParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) MarkChirpDeleted(ctx context.Context, arg MarkChirpDeletedParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, markChirpDeleted, arg.ID, arg.UserID)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.SearchVector,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.HiddenAt,
+	)
+	return i, err
+}
+
+const removeChirp = `-- name: RemoveChirp :one
+UPDATE chirps
+SET updated_at = NOW(), deleted_at = NOW(), body = ''
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING id, created_at, updated_at, body, user_id, search_vector, in_reply_to, deleted_at, rechirp_of, hidden_at
+`
+
+func (q *Queries) RemoveChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, removeChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.SearchVector,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.HiddenAt,
+	)
+	return i, err
+}
+
 const resetChirps = `-- name: ResetChirps :many
 DELETE FROM chirps *
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, search_vector, in_reply_to, deleted_at, rechirp_of, hidden_at
 `
 
 func (q *Queries) ResetChirps(ctx context.Context) ([]Chirp, error) {
@@ -168,6 +391,85 @@ func (q *Queries) ResetChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.SearchVector,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.RechirpOf,
+			&i.HiddenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchChirps = `-- name: SearchChirps :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, rechirp_of,
+    hidden_at, rank
+FROM (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, rechirp_of,
+        hidden_at, ts_rank(search_vector, to_tsquery('english', $1))::real AS rank
+    FROM chirps
+    WHERE deleted_at IS NULL
+    AND search_vector @@ to_tsquery('english', $1)
+    AND ($2::uuid IS NULL OR user_id = $2)
+    AND (hidden_at IS NULL OR user_id = $3)
+) AS results
+WHERE ($4::real IS NULL
+    OR (rank, created_at, id) < ($4,
+        $5::timestamp, $6::uuid))
+ORDER BY rank DESC, created_at DESC, id DESC
+LIMIT $7
+`
+
+type SearchChirpsParams struct {
+	Query           string
+	AuthorID        uuid.NullUUID
+	ViewerID        uuid.NullUUID
+	CursorRank      sql.NullFloat64
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	Limit           int32
+}
+
+type SearchChirpsRow struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Body      string
+	UserID    uuid.UUID
+	InReplyTo uuid.NullUUID
+	RechirpOf uuid.NullUUID
+	HiddenAt  sql.NullTime
+	Rank      float32
+}
+
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps, arg.Query, arg.AuthorID, arg.ViewerID, arg.CursorRank, arg.CursorCreatedAt, arg.CursorID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsRow
+	for rows.Next() {
+		var i SearchChirpsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.RechirpOf,
+			&i.HiddenAt,
+			&i.Rank,
 		); err != nil {
 			return nil, err
 		}
@@ -181,3 +483,102 @@ func (q *Queries) ResetChirps(ctx context.Context) ([]Chirp, error) {
 	}
 	return items, nil
 }
+
+const searchChirpsReverse = `-- name: SearchChirpsReverse :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, rechirp_of,
+    hidden_at, rank
+FROM (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, rechirp_of,
+        hidden_at, ts_rank(search_vector, to_tsquery('english', $1))::real AS rank
+    FROM chirps
+    WHERE deleted_at IS NULL
+    AND search_vector @@ to_tsquery('english', $1)
+    AND ($2::uuid IS NULL OR user_id = $2)
+    AND (hidden_at IS NULL OR user_id = $3)
+) AS results
+WHERE ($4::real IS NULL
+    OR (rank, created_at, id) > ($4,
+        $5::timestamp, $6::uuid))
+ORDER BY rank ASC, created_at ASC, id ASC
+LIMIT $7
+`
+
+type SearchChirpsReverseParams struct {
+	Query           string
+	AuthorID        uuid.NullUUID
+	ViewerID        uuid.NullUUID
+	CursorRank      sql.NullFloat64
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	Limit           int32
+}
+
+type SearchChirpsReverseRow struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Body      string
+	UserID    uuid.UUID
+	InReplyTo uuid.NullUUID
+	RechirpOf uuid.NullUUID
+	HiddenAt  sql.NullTime
+	Rank      float32
+}
+
+func (q *Queries) SearchChirpsReverse(ctx context.Context, arg SearchChirpsReverseParams) ([]SearchChirpsReverseRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirpsReverse, arg.Query, arg.AuthorID, arg.ViewerID, arg.CursorRank, arg.CursorCreatedAt, arg.CursorID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsReverseRow
+	for rows.Next() {
+		var i SearchChirpsReverseRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.RechirpOf,
+			&i.HiddenAt,
+			&i.Rank,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const unhideChirp = `-- name: UnhideChirp :one
+UPDATE chirps
+SET hidden_at = NULL
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING id, created_at, updated_at, body, user_id, search_vector, in_reply_to, deleted_at, rechirp_of, hidden_at
+`
+
+func (q *Queries) UnhideChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, unhideChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.SearchVector,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.HiddenAt,
+	)
+	return i, err
+}
@@ -11,11 +11,66 @@ import (
 	"github.com/google/uuid"
 )
 
+const listSubscriptionEvents = `-- name: ListSubscriptionEvents :many
+SELECT id, created_at, user_id, event
+FROM subscription_events
+WHERE user_id = $1
+ORDER BY created_at, id
+`
+
+func (q *Queries) ListSubscriptionEvents(ctx context.Context, userID uuid.UUID) ([]SubscriptionEvent, error) {
+	rows, err := q.db.QueryContext(ctx, listSubscriptionEvents, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SubscriptionEvent
+	for rows.Next() {
+		var i SubscriptionEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UserID,
+			&i.Event,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const recordSubscriptionEvent = `-- name: RecordSubscriptionEvent :exec
+INSERT INTO subscription_events (id, created_at, user_id, event)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2
+)
+`
+
+type RecordSubscriptionEventParams struct {
+	UserID uuid.UUID
+	Event  string
+}
+
+func (q *Queries) RecordSubscriptionEvent(ctx context.Context, arg RecordSubscriptionEventParams) error {
+	_, err := q.db.ExecContext(ctx, recordSubscriptionEvent, arg.UserID, arg.Event)
+	return err
+}
+
 const upgradeToChirpyRed = `-- name: UpgradeToChirpyRed :one
 UPDATE users
 SET updated_at = NOW(), is_chirpy_red = true
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, handle, display_name, bio, avatar_url, tokens_valid_after, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, role, delete_after
 `
 
 func (q *Queries) UpgradeToChirpyRed(ctx context.Context, id uuid.UUID) (User, error) {
@@ -28,6 +83,17 @@ func (q *Queries) UpgradeToChirpyRed(ctx context.Context, id uuid.UUID) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.TokensValidAfter,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeleteAfter,
 	)
 	return i, err
 }
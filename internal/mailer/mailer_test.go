@@ -0,0 +1,71 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	msg := Message{
+		To:      "user@example.com",
+		Subject: "Reset your password",
+		Body:    "line one\nline two",
+	}
+
+	body, err := format("chirpy@example.com", msg, time.Unix(0, 0).UTC())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := "From: chirpy@example.com\r\n" +
+		"To: user@example.com\r\n" +
+		"Subject: Reset your password\r\n" +
+		"Date: Thu, 01 Jan 1970 00:00:00 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"line one\r\nline two\r\n"
+
+	if string(body) != expected {
+		t.Errorf("Expected:\n%q\nbut got:\n%q", expected, body)
+	}
+}
+
+func TestFormatRejectsHeaderInjection(t *testing.T) {
+	msg := Message{
+		To:      "user@example.com\r\nBcc: someone@example.com",
+		Subject: "Hello",
+	}
+
+	_, err := format("chirpy@example.com", msg, time.Now())
+	if err == nil {
+		t.Errorf("Line breaks in headers should be rejected")
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.txt")
+	m := &FileMailer{Path: path, From: "chirpy@example.com"}
+
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		err := m.Send(context.Background(), Message{To: to, Subject: "Hi", Body: "Hello"})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, to := range []string{"To: a@example.com", "To: b@example.com"} {
+		if !strings.Contains(string(contents), to) {
+			t.Errorf("Expected %q in mail file:\n%s", to, contents)
+		}
+	}
+}
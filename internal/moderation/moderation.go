@@ -0,0 +1,200 @@
+package moderation
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+type Action string
+
+const (
+	// Replace the word with asterisks and accept the chirp
+	ActionMask Action = "mask"
+	// Refuse the chirp entirely
+	ActionReject Action = "reject"
+	// Accept the chirp unchanged but queue it for a moderator
+	ActionFlag Action = "flag"
+)
+
+func ParseAction(s string) (Action, error) {
+	switch a := Action(strings.ToLower(strings.TrimSpace(s))); a {
+	case ActionMask, ActionReject, ActionFlag:
+		return a, nil
+	}
+	return "", fmt.Errorf("Unknown moderation action: '%v'", s)
+}
+
+type Rule struct {
+	Word   string
+	Action Action
+}
+
+type Match struct {
+	Rule Rule
+	Text string // As written in the original body
+}
+
+type Result struct {
+	Body     string // Body with masked words replaced
+	Rejected bool
+	Flagged  bool
+	Matches  []Match
+}
+
+// Stages of the moderation pipeline
+// A filter receives the body as left by the previous stage
+type Filter interface {
+	Check(body string) Result
+}
+
+// Runs each filter in order on the output of the previous one
+type Chain []Filter
+
+func (c Chain) Check(body string) Result {
+	result := Result{Body: body}
+	for _, f := range c {
+		r := f.Check(result.Body)
+		result.Body = r.Body
+		result.Rejected = result.Rejected || r.Rejected
+		result.Flagged = result.Flagged || r.Flagged
+		result.Matches = append(result.Matches, r.Matches...)
+	}
+	return result
+}
+
+func DefaultRules() []Rule {
+	return []Rule{
+		{Word: "kerfuffle", Action: ActionMask},
+		{Word: "sharbert", Action: ActionMask},
+		{Word: "fornax", Action: ActionMask},
+	}
+}
+
+// Matches whole words regardless of case, punctuation, accents, compatibility
+// forms such as fullwidth letters and common character substitutions
+// Safe for concurrent use; rules can be replaced at runtime
+type WordFilter struct {
+	mu    sync.RWMutex
+	rules map[string]Rule
+}
+
+func NewWordFilter(rules []Rule) *WordFilter {
+	f := &WordFilter{}
+	f.SetRules(rules)
+	return f
+}
+
+// Later rules win when several normalize to the same word
+func (f *WordFilter) SetRules(rules []Rule) {
+	m := map[string]Rule{}
+	for _, rule := range rules {
+		word := Normalize(rule.Word)
+		if len(word) == 0 {
+			continue
+		}
+		m[word] = rule
+	}
+
+	f.mu.Lock()
+	f.rules = m
+	f.mu.Unlock()
+}
+
+func (f *WordFilter) Check(body string) Result {
+	f.mu.RLock()
+	rules := f.rules
+	f.mu.RUnlock()
+
+	result := Result{}
+	out := strings.Builder{}
+	last := 0
+
+	for _, span := range words(body) {
+		text := body[span.start:span.end]
+		rule, ok := rules[Normalize(text)]
+		if !ok {
+			continue
+		}
+
+		result.Matches = append(result.Matches, Match{Rule: rule, Text: text})
+
+		switch rule.Action {
+		case ActionReject:
+			result.Rejected = true
+		case ActionFlag:
+			result.Flagged = true
+		case ActionMask:
+			out.WriteString(body[last:span.start])
+			out.WriteString("****")
+			last = span.end
+		}
+	}
+
+	out.WriteString(body[last:])
+	result.Body = out.String()
+	return result
+}
+
+var substitutions = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'@': 'a',
+	'$': 's',
+}
+
+// Reduces a word to the form rules are matched against
+func Normalize(word string) string {
+	b := strings.Builder{}
+	for _, r := range norm.NFKD.String(word) {
+		if unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Cf, r) {
+			continue
+		}
+		if s, ok := substitutions[r]; ok {
+			r = s
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+type span struct {
+	start, end int
+}
+
+func isWordRune(r rune) bool {
+	if _, ok := substitutions[r]; ok {
+		return true
+	}
+	return unicode.IsLetter(r) || unicode.IsDigit(r) ||
+		unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Cf, r)
+}
+
+// Byte ranges of the words in s
+func words(s string) []span {
+	spans := []span{}
+	start := -1
+	for i, r := range s {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			spans = append(spans, span{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, span{start, len(s)})
+	}
+	return spans
+}
@@ -0,0 +1,113 @@
+package moderation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMaskDefaultRules(t *testing.T) {
+	f := NewWordFilter(DefaultRules())
+
+	cases := map[string]string{
+		"I had a kerfuffle today":      "I had a **** today",
+		"What a Kerfuffle!":            "What a ****!",
+		"KERFUFFLE, sharbert; fornax.": "****, ****; ****.",
+		"kérfüffle":                    "****",
+		"\uff4b\uff45\uff52\uff46\uff55\uff46\uff46\uff4c\uff45": "****",
+		"k3rfuffl3":              "****",
+		"ker\u200bfuffle":        "****",
+		"kerfuffles":             "kerfuffles",
+		"a perfectly fine chirp": "a perfectly fine chirp",
+	}
+
+	for body, expected := range cases {
+		result := f.Check(body)
+		if result.Body != expected {
+			t.Errorf("Check(%q): expected %q but got %q", body, expected, result.Body)
+		}
+		if result.Rejected || result.Flagged {
+			t.Errorf("Check(%q): mask rules shouldn't reject or flag", body)
+		}
+	}
+}
+
+func TestRejectAndFlag(t *testing.T) {
+	f := NewWordFilter([]Rule{
+		{Word: "spam", Action: ActionReject},
+		{Word: "hmm", Action: ActionFlag},
+	})
+
+	result := f.Check("buy SPAM now")
+	if !result.Rejected {
+		t.Errorf("Expected rejection: %+v", result)
+	}
+
+	result = f.Check("hmm, interesting")
+	if !result.Flagged || result.Rejected {
+		t.Errorf("Expected flag only: %+v", result)
+	}
+	if result.Body != "hmm, interesting" {
+		t.Errorf("Flagged body shouldn't change: %q", result.Body)
+	}
+	if len(result.Matches) != 1 || result.Matches[0].Text != "hmm" {
+		t.Errorf("Unexpected matches: %+v", result.Matches)
+	}
+}
+
+func TestSetRules(t *testing.T) {
+	f := NewWordFilter(DefaultRules())
+	f.SetRules([]Rule{{Word: "fornax", Action: ActionReject}})
+
+	if f.Check("kerfuffle").Body != "kerfuffle" {
+		t.Errorf("Old rules still applied")
+	}
+	if !f.Check("Fornax").Rejected {
+		t.Errorf("New rules not applied")
+	}
+}
+
+func TestChain(t *testing.T) {
+	c := Chain{
+		NewWordFilter([]Rule{{Word: "kerfuffle", Action: ActionMask}}),
+		NewWordFilter([]Rule{{Word: "hmm", Action: ActionFlag}}),
+	}
+
+	result := c.Check("hmm a kerfuffle")
+	if result.Body != "hmm a ****" || !result.Flagged || len(result.Matches) != 2 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	rules, err := LoadRules(strings.NewReader(`
+# comment
+kerfuffle
+spam reject
+
+hmm FLAG
+`))
+	if err != nil {
+		t.Errorf("Got error: %v", err)
+		return
+	}
+
+	expected := []Rule{
+		{Word: "kerfuffle", Action: ActionMask},
+		{Word: "spam", Action: ActionReject},
+		{Word: "hmm", Action: ActionFlag},
+	}
+	if len(rules) != len(expected) {
+		t.Errorf("Expected %v rules but got %v", len(expected), rules)
+		return
+	}
+	for i := range rules {
+		if rules[i] != expected[i] {
+			t.Errorf("Expected %+v but got %+v", expected[i], rules[i])
+		}
+	}
+
+	_, err = LoadRules(strings.NewReader("spam delete"))
+	if err == nil {
+		t.Errorf("Unknown action should error")
+	}
+}
@@ -0,0 +1,47 @@
+package moderation
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Reads one rule per line as "word [action]", defaulting to mask
+// Blank lines and lines starting with # are ignored
+func LoadRules(r io.Reader) ([]Rule, error) {
+	rules := []Rule{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		rule := Rule{Word: fields[0], Action: ActionMask}
+
+		switch len(fields) {
+		case 1:
+		case 2:
+			action, err := ParseAction(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("Line %v: %w", line, err)
+			}
+			rule.Action = action
+		default:
+			return nil, fmt.Errorf("Line %v: expected 'word [action]': %v", line, text)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
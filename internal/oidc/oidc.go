@@ -0,0 +1,347 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/auth"
+)
+
+// Limits how often an unknown key ID makes the provider's keys be fetched
+// again, so tokens with made up key IDs can't be used to hammer it
+const keyRefreshInterval = time.Minute
+
+// Responses from the provider larger than this are refused
+const maxResponseSize = 1 << 20
+
+// The parts of the OpenID Connect discovery document Chirpy uses
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// The verified identity from an ID token
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// A relying party for a single OpenID Connect provider
+// The discovery document and signing keys are fetched on first use, so a
+// provider that is down doesn't stop Chirpy starting
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Defaults to http.DefaultClient
+	HTTPClient *http.Client
+	// Allowed clock difference with the provider when checking times
+	Leeway time.Duration
+
+	mu             sync.Mutex
+	metadata       *Metadata
+	keys           *auth.Keyring
+	keysFetchedAt  time.Time
+	keysRefreshing bool
+}
+
+func (p *Provider) client() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	return p.doJSON(req, v)
+}
+
+func (p *Provider) doJSON(req *http.Request, v any) error {
+	res, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v %v: %v: %s", req.Method, req.URL, res.Status, body)
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// Fetches the discovery document the first time it is needed
+func (p *Provider) Metadata(ctx context.Context) (Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return *p.metadata, nil
+	}
+
+	metadata := Metadata{}
+	err := p.getJSON(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", &metadata)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("Failed to discover provider: %w", err)
+	}
+
+	// OpenID Connect Discovery section 4.3
+	if metadata.Issuer != p.Issuer {
+		return Metadata{}, fmt.Errorf("Provider claims to be %v, not %v", metadata.Issuer, p.Issuer)
+	}
+
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return Metadata{}, fmt.Errorf("Discovery document is missing endpoints")
+	}
+
+	p.metadata = &metadata
+	return metadata, nil
+}
+
+// Fetches the provider's signing keys; keys that can't be used are skipped
+// A provider with a single key may leave kid out of its tokens
+func (p *Provider) fetchKeys(ctx context.Context, jwksURI string) (*auth.Keyring, error) {
+	set := auth.JWKSet{}
+	err := p.getJSON(ctx, jwksURI, &set)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch provider keys: %w", err)
+	}
+
+	keys := auth.NewKeyring()
+	keys.Issuer = p.Issuer
+	keys.Audience = p.ClientID
+	keys.Leeway = p.Leeway
+
+	count := 0
+	var last *auth.SigningKey
+	for _, jwk := range set.Keys {
+		key, err := auth.ParseJWK(jwk)
+		if err != nil {
+			continue
+		}
+		err = keys.Add(key, false)
+		if err != nil {
+			continue
+		}
+		count++
+		last = key
+	}
+
+	if count == 0 {
+		return nil, fmt.Errorf("Provider has no usable signing keys")
+	}
+	if count == 1 {
+		keys.SetLegacyKey(last)
+	}
+
+	return keys, nil
+}
+
+// The provider's keys, fetched again if refresh is set and they haven't been
+// fetched recently
+func (p *Provider) signingKeys(ctx context.Context, refresh bool) (*auth.Keyring, error) {
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	keys := p.keys
+	stale := keys == nil ||
+		(refresh && !p.keysRefreshing && time.Since(p.keysFetchedAt) > keyRefreshInterval)
+	if !stale {
+		p.mu.Unlock()
+		return keys, nil
+	}
+	p.keysRefreshing = true
+	p.mu.Unlock()
+
+	fetched, err := p.fetchKeys(ctx, metadata.JWKSURI)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keysRefreshing = false
+
+	if err != nil {
+		if keys != nil {
+			return keys, nil
+		}
+		return nil, err
+	}
+
+	p.keys = fetched
+	p.keysFetchedAt = time.Now()
+	return fetched, nil
+}
+
+// Where to send the browser to sign in with the provider
+// state and nonce must be unguessable and remembered for the callback; the
+// code challenge comes from a PKCE verifier kept for Exchange
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("Invalid authorization endpoint: %w", err)
+	}
+
+	query := u.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", "openid email profile")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", auth.PKCEMethodS256)
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// Redeems an authorization code and verifies the ID token it was exchanged
+// for
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (Identity, error) {
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	response := struct {
+		IDToken string `json:"id_token"`
+	}{}
+
+	err = p.doJSON(req, &response)
+	if err != nil {
+		return Identity{}, fmt.Errorf("Failed to redeem code: %w", err)
+	}
+
+	if response.IDToken == "" {
+		return Identity{}, fmt.Errorf("Provider returned no ID token")
+	}
+
+	return p.VerifyIDToken(ctx, response.IDToken, nonce)
+}
+
+// Accepts email_verified as a boolean or, as some providers send it, a
+// string
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	case "false", `"false"`, "null":
+		*b = false
+	default:
+		return fmt.Errorf("Invalid boolean: %s", data)
+	}
+	return nil
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce           string       `json:"nonce"`
+	AuthorizedParty string       `json:"azp"`
+	Email           string       `json:"email"`
+	EmailVerified   flexibleBool `json:"email_verified"`
+	Name            string       `json:"name"`
+}
+
+// Checks an ID token as OpenID Connect Core section 3.1.3.7 describes: it
+// must be signed by one of the provider's keys, issued by the provider for
+// this client, unexpired, and carry the nonce sent with the login
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (Identity, error) {
+	keys, err := p.signingKeys(ctx, false)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(p.Leeway),
+	}
+
+	claims := idTokenClaims{}
+	_, err = keys.Parse(raw, &claims, options...)
+	if err != nil {
+		// The provider may have rotated its keys since they were fetched
+		refreshed, refreshErr := p.signingKeys(ctx, true)
+		if refreshErr != nil || refreshed == keys {
+			return Identity{}, fmt.Errorf("Invalid ID token: %w", err)
+		}
+
+		claims = idTokenClaims{}
+		_, err = refreshed.Parse(raw, &claims, options...)
+		if err != nil {
+			return Identity{}, fmt.Errorf("Invalid ID token: %w", err)
+		}
+	}
+
+	if claims.Subject == "" {
+		return Identity{}, fmt.Errorf("ID token has no subject")
+	}
+
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID {
+		return Identity{}, fmt.Errorf("ID token was issued to %v", claims.AuthorizedParty)
+	}
+
+	if nonce == "" || claims.Nonce != nonce {
+		return Identity{}, fmt.Errorf("ID token nonce doesn't match")
+	}
+
+	return Identity{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+	}, nil
+}
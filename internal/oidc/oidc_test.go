@@ -0,0 +1,325 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/auth"
+)
+
+const (
+	testClientID     = "chirpy"
+	testClientSecret = "secret"
+	testRedirectURL  = "https://chirpy.example/api/oidc/callback"
+	testVerifier     = "dBjftJeZ4CVP-mJ92K9ohXxE2iL3x1gk8Ehv6Y9N0aQ7w"
+)
+
+// An in-process identity provider that hands out one code at a time
+type fakeProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	keys   *auth.Keyring
+	key    *auth.SigningKey
+
+	// What the next code is exchanged for
+	code      string
+	challenge string
+	claims    jwt.MapClaims
+	// Counts requests for the signing keys
+	jwksFetches int
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	f := &fakeProvider{t: t}
+	f.rotateKey("key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Metadata{
+			Issuer:                f.server.URL,
+			AuthorizationEndpoint: f.server.URL + "/authorize",
+			TokenEndpoint:         f.server.URL + "/token",
+			JWKSURI:               f.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		f.jwksFetches++
+		json.NewEncoder(w).Encode(f.keys.JWKS())
+	})
+	mux.HandleFunc("POST /token", f.tokenHandler)
+
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeProvider) rotateKey(id string) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		f.t.Fatalf("Test is broken: %v", err)
+	}
+	f.key = auth.NewEd25519Key(id, private)
+	f.keys = auth.NewKeyring()
+	f.keys.Add(f.key, true)
+}
+
+func (f *fakeProvider) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != testClientID || secret != testClientSecret {
+		w.WriteHeader(401)
+		return
+	}
+
+	r.ParseForm()
+	if r.PostForm.Get("code") != f.code || f.code == "" ||
+		r.PostForm.Get("redirect_uri") != testRedirectURL ||
+		auth.VerifyPKCE(r.PostForm.Get("code_verifier"), f.challenge) != nil {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	f.code = ""
+
+	idToken, err := f.keys.Sign(f.claims)
+	if err != nil {
+		f.t.Fatalf("Test is broken: %v", err)
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "unused",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+// Approves a login as if the user had signed in, returning the code
+func (f *fakeProvider) approve(authURL string, claims jwt.MapClaims) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		f.t.Fatalf("Invalid authorization URL: %v", err)
+	}
+	query := u.Query()
+
+	now := time.Now()
+	base := jwt.MapClaims{
+		"iss":   f.server.URL,
+		"aud":   testClientID,
+		"sub":   "user-1",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Minute).Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for k, v := range claims {
+		base[k] = v
+	}
+
+	f.code = "code-" + query.Get("state")
+	f.challenge = query.Get("code_challenge")
+	f.claims = base
+	return f.code
+}
+
+func (f *fakeProvider) provider() *Provider {
+	return &Provider{
+		Issuer:       f.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		HTTPClient:   f.server.Client(),
+	}
+}
+
+func TestLogin(t *testing.T) {
+	f := newFakeProvider(t)
+	p := f.provider()
+	ctx := context.Background()
+
+	authURL, err := p.AuthCodeURL(ctx, "state", "nonce", auth.PKCEChallenge(testVerifier))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !strings.HasPrefix(authURL, f.server.URL+"/authorize?") {
+		t.Errorf("Unexpected authorization URL: %v", authURL)
+	}
+
+	u, _ := url.Parse(authURL)
+	for key, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge_method": "S256",
+	} {
+		if got := u.Query().Get(key); got != want {
+			t.Errorf("%v: expected %q but got %q", key, want, got)
+		}
+	}
+
+	code := f.approve(authURL, jwt.MapClaims{
+		"email":          "staff@example.com",
+		"email_verified": true,
+		"name":           "Staff Member",
+	})
+
+	identity, err := p.Exchange(ctx, code, testVerifier, "nonce")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := Identity{
+		Issuer:        f.server.URL,
+		Subject:       "user-1",
+		Email:         "staff@example.com",
+		EmailVerified: true,
+		Name:          "Staff Member",
+	}
+	if identity != expected {
+		t.Errorf("Expected %+v but got %+v", expected, identity)
+	}
+
+	_, err = p.Exchange(ctx, code, testVerifier, "nonce")
+	if err == nil {
+		t.Errorf("Code should only be redeemable once")
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	f := newFakeProvider(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		verifier string
+		nonce    string
+	}{
+		{"wrong verifier", nil, strings.Repeat("a", 43), "nonce"},
+		{"wrong nonce", nil, testVerifier, "other"},
+		{"wrong audience", jwt.MapClaims{"aud": "someone-else"}, testVerifier, "nonce"},
+		{"wrong issuer", jwt.MapClaims{"iss": "https://evil.example"}, testVerifier, "nonce"},
+		{"expired", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}, testVerifier, "nonce"},
+		{"no expiry", jwt.MapClaims{"exp": nil}, testVerifier, "nonce"},
+		{"no subject", jwt.MapClaims{"sub": ""}, testVerifier, "nonce"},
+		{"other authorized party", jwt.MapClaims{
+			"aud": []string{testClientID, "other"},
+			"azp": "other",
+		}, testVerifier, "nonce"},
+	}
+
+	for _, tt := range tests {
+		p := f.provider()
+		authURL, err := p.AuthCodeURL(ctx, "state", "nonce", auth.PKCEChallenge(testVerifier))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		claims := jwt.MapClaims{}
+		for k, v := range tt.claims {
+			claims[k] = v
+		}
+		code := f.approve(authURL, claims)
+		for k, v := range tt.claims {
+			if v == nil {
+				delete(f.claims, k)
+			}
+		}
+
+		_, err = p.Exchange(ctx, code, tt.verifier, tt.nonce)
+		if err == nil {
+			t.Errorf("%v: should be rejected", tt.name)
+		}
+	}
+}
+
+func TestVerifyIDTokenKeyRotation(t *testing.T) {
+	f := newFakeProvider(t)
+	p := f.provider()
+	ctx := context.Background()
+
+	sign := func() string {
+		authURL, err := p.AuthCodeURL(ctx, "state", "nonce", auth.PKCEChallenge(testVerifier))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		f.approve(authURL, nil)
+		s, err := f.keys.Sign(f.claims)
+		if err != nil {
+			t.Fatalf("Test is broken: %v", err)
+		}
+		return s
+	}
+
+	_, err := p.VerifyIDToken(ctx, sign(), "nonce")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	f.rotateKey("key-2")
+	// Pretend the keys were fetched long enough ago to be refreshed
+	p.keysFetchedAt = time.Now().Add(-2 * keyRefreshInterval)
+
+	_, err = p.VerifyIDToken(ctx, sign(), "nonce")
+	if err != nil {
+		t.Errorf("Token signed with a new key should verify after a refresh: %v", err)
+	}
+	if f.jwksFetches != 2 {
+		t.Errorf("Expected keys to be fetched twice but got %v", f.jwksFetches)
+	}
+
+	// A key nobody published only causes one fetch per interval
+	_, private, _ := ed25519.GenerateKey(rand.Reader)
+	forged := auth.NewKeyring()
+	forged.Add(auth.NewEd25519Key("key-3", private), true)
+	s, _ := forged.Sign(f.claims)
+
+	for range 3 {
+		_, err = p.VerifyIDToken(ctx, s, "nonce")
+		if err == nil {
+			t.Errorf("Token signed with an unpublished key should be rejected")
+		}
+	}
+	if f.jwksFetches != 2 {
+		t.Errorf("Unknown keys shouldn't refetch within the interval, got %v fetches", f.jwksFetches)
+	}
+}
+
+func TestMetadataIssuerMismatch(t *testing.T) {
+	f := newFakeProvider(t)
+	p := f.provider()
+	p.Issuer = f.server.URL + "/"
+
+	_, err := p.Metadata(context.Background())
+	if err == nil {
+		t.Errorf("Discovery document for another issuer should be rejected")
+	}
+}
+
+func TestFlexibleBool(t *testing.T) {
+	for input, expected := range map[string]bool{
+		`true`: true, `"true"`: true, `false`: false, `"false"`: false, `null`: false,
+	} {
+		var b flexibleBool
+		err := json.Unmarshal([]byte(input), &b)
+		if err != nil || bool(b) != expected {
+			t.Errorf("%v: expected %v but got %v (%v)", input, expected, b, err)
+		}
+	}
+
+	var b flexibleBool
+	err := json.Unmarshal([]byte(`"yes"`), &b)
+	if err == nil {
+		t.Errorf("Unexpected value should be rejected")
+	}
+}
@@ -0,0 +1,206 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Length of the hash prefix ranges are looked up by, as in the Pwned
+// Passwords range API
+const rangePrefixLength = 5
+
+// Breached password hashes grouped into ranges by prefix, so a lookup only
+// compares suffixes within one range. This mirrors the k-anonymity range
+// API, letting a remote range source stand in for a local file later
+type HashList struct {
+	ranges map[string]map[string]int
+}
+
+func hashPassword(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// Reads one uppercase or lowercase SHA-1 hash per line, optionally followed
+// by ":count" as in the Pwned Passwords downloads
+// Blank lines and lines starting with # are ignored
+func LoadHashList(r io.Reader) (*HashList, error) {
+	l := &HashList{ranges: map[string]map[string]int{}}
+	scanner := bufio.NewScanner(r)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		hash, countText, _ := strings.Cut(text, ":")
+		hash = strings.ToUpper(hash)
+
+		_, err := hex.DecodeString(hash)
+		if err != nil || len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("Line %v: invalid SHA-1 hash: %q", line, hash)
+		}
+
+		count := 1
+		if countText != "" {
+			_, err = fmt.Sscan(countText, &count)
+			if err != nil {
+				return nil, fmt.Errorf("Line %v: invalid count: %q", line, countText)
+			}
+		}
+
+		prefix, suffix := hash[:rangePrefixLength], hash[rangePrefixLength:]
+		if l.ranges[prefix] == nil {
+			l.ranges[prefix] = map[string]int{}
+		}
+		l.ranges[prefix][suffix] += count
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func LoadHashListFile(path string) (*HashList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open breached password list: %w", err)
+	}
+	defer f.Close()
+
+	l, err := LoadHashList(f)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load breached password list %v: %w", path, err)
+	}
+	return l, nil
+}
+
+// The suffixes of breached hashes starting with prefix, with how often each
+// was seen
+func (l *HashList) Range(prefix string) map[string]int {
+	return l.ranges[strings.ToUpper(prefix)]
+}
+
+func (l *HashList) IsBreached(password string) (bool, error) {
+	hash := hashPassword(password)
+	_, ok := l.Range(hash[:rangePrefixLength])[hash[rangePrefixLength:]]
+	return ok, nil
+}
+
+// A breached password list looked up in place rather than loaded, for lists
+// too big to hold in memory such as the full Pwned Passwords download
+// Lines must be sorted by hash, as in the "ordered by hash" download, since
+// each lookup is a binary search over the file
+type HashFile struct {
+	r    io.ReaderAt
+	size int64
+}
+
+func NewHashFile(r io.ReaderAt, size int64) *HashFile {
+	return &HashFile{r: r, size: size}
+}
+
+// Opens a sorted hash list, which stays open for as long as it is used
+func OpenHashFile(path string) (*HashFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open breached password list: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Failed to open breached password list: %w", err)
+	}
+
+	l := NewHashFile(f, info.Size())
+
+	// Catches pointing it at the wrong file, though not an unsorted one
+	_, _, line, err := l.lineAfter(0)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Failed to read breached password list %v: %w", path, err)
+	}
+	hash := lineHash(line)
+	_, err = hex.DecodeString(hash)
+	if err != nil || len(hash) != sha1.Size*2 {
+		f.Close()
+		return nil, fmt.Errorf("Breached password list %v does not start with a SHA-1 hash", path)
+	}
+
+	return l, nil
+}
+
+func lineHash(line string) string {
+	hash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+	return strings.ToUpper(hash)
+}
+
+// Finds the first line starting at or after off, returning where it starts
+// and where the next one does
+func (l *HashFile) lineAfter(off int64) (int64, int64, string, error) {
+	start := off
+	if off > 0 {
+		// off may be in the middle of a line, so skip to the end of it
+		start = off - 1
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(l.r, start, l.size-start))
+
+	if off > 0 {
+		skipped, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return l.size, l.size, "", nil
+		}
+		if err != nil {
+			return 0, 0, "", err
+		}
+		start += int64(len(skipped))
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, 0, "", err
+	}
+
+	return start, start + int64(len(line)), line, nil
+}
+
+func (l *HashFile) IsBreached(password string) (bool, error) {
+	hash := hashPassword(password)
+
+	// Every line before lo sorts before hash, and every line starting at or
+	// after hi sorts at or after it
+	lo, hi := int64(0), l.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, end, line, err := l.lineAfter(mid)
+		if err != nil {
+			return false, fmt.Errorf("Failed to read breached password list: %w", err)
+		}
+
+		if start >= hi || lineHash(line) >= hash {
+			hi = mid
+		} else {
+			lo = end
+		}
+	}
+
+	_, _, line, err := l.lineAfter(lo)
+	if err != nil {
+		return false, fmt.Errorf("Failed to read breached password list: %w", err)
+	}
+
+	return lineHash(line) == hash, nil
+}
@@ -0,0 +1,91 @@
+package passwordpolicy
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// NIST SP 800-63B recommends at least 8 characters and no composition
+// rules. The maximum is in bytes since that is what hashing costs
+const (
+	DefaultMinLength = 8
+	DefaultMaxLength = 256
+)
+
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Returned by Check when a password breaks one or more rules
+type Error struct {
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	messages := []string{}
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+	return "Password rejected: " + strings.Join(messages, "; ")
+}
+
+// Reports whether a password is known to have leaked
+type BreachChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// Optional
+	Breached BreachChecker
+}
+
+func Default() Policy {
+	return Policy{
+		MinLength: DefaultMinLength,
+		MaxLength: DefaultMaxLength,
+	}
+}
+
+// Returns an *Error listing every rule the password breaks, or another
+// error if the breach list couldn't be checked
+func (p Policy) Check(password string) error {
+	violations := []Violation{}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, Violation{
+			Rule:    "min_length",
+			Message: fmt.Sprintf("Password must be at least %v characters", p.MinLength),
+		})
+	}
+
+	// Hashing is deliberately slow, so huge passwords are turned away
+	// before getting that far
+	if len(password) > p.MaxLength {
+		violations = append(violations, Violation{
+			Rule:    "max_length",
+			Message: fmt.Sprintf("Password must be at most %v bytes", p.MaxLength),
+		})
+	}
+
+	if p.Breached != nil && len(password) > 0 {
+		breached, err := p.Breached.IsBreached(password)
+		if err != nil {
+			return fmt.Errorf("Failed to check breached passwords: %w", err)
+		}
+		if breached {
+			violations = append(violations, Violation{
+				Rule:    "breached",
+				Message: "Password appears in a list of breached passwords",
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &Error{Violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package passwordpolicy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// SHA-1 of "password" and "123456789"
+const testList = `# breached
+5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824
+f7c3bc1d808e04732adf679965ccc34ca7ae3441
+`
+
+func rules(err error) []string {
+	var policyErr *Error
+	if !errors.As(err, &policyErr) {
+		return nil
+	}
+	result := []string{}
+	for _, v := range policyErr.Violations {
+		result = append(result, v.Rule)
+	}
+	return result
+}
+
+func TestCheck(t *testing.T) {
+	list, err := LoadHashList(strings.NewReader(testList))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	p := Default()
+	p.Breached = list
+
+	cases := []struct {
+		password string
+		rules    []string
+	}{
+		{"correct horse battery staple", nil},
+		{"short", []string{"min_length"}},
+		{"", []string{"min_length"}},
+		{"password", []string{"breached"}},
+		{"123456789", []string{"breached"}},
+		{strings.Repeat("a", DefaultMaxLength+1), []string{"max_length"}},
+	}
+
+	for _, c := range cases {
+		err := p.Check(c.password)
+		got := rules(err)
+		if strings.Join(got, ",") != strings.Join(c.rules, ",") {
+			t.Errorf("Check(%.20q): expected %v but got %v (%v)", c.password, c.rules, got, err)
+		}
+	}
+}
+
+func TestCheckListsEveryViolation(t *testing.T) {
+	p := Default()
+	p.MinLength = 300
+
+	got := rules(p.Check(strings.Repeat("a", DefaultMaxLength+1)))
+	if strings.Join(got, ",") != "min_length,max_length" {
+		t.Errorf("Expected both length rules but got %v", got)
+	}
+}
+
+func TestLoadHashListRejectsBadLines(t *testing.T) {
+	for _, list := range []string{"nothex", "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:many"} {
+		_, err := LoadHashList(strings.NewReader(list))
+		if err == nil {
+			t.Errorf("Expected an error for %q", list)
+		}
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	// Sorted by hash, with the counts and line endings of the downloads
+	list := "0000000CAEF405439D57847A8657218C618160B2:2\r\n" +
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\r\n" +
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD9:1\r\n" +
+		"F7C3BC1D808E04732ADF679965CCC34CA7AE3441:7016669\r\n"
+
+	l := NewHashFile(strings.NewReader(list), int64(len(list)))
+
+	cases := []struct {
+		password string
+		breached bool
+	}{
+		{"password", true},
+		{"123456789", true},
+		{"correct horse battery staple", false},
+		{"", false},
+	}
+
+	for _, c := range cases {
+		breached, err := l.IsBreached(c.password)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if breached != c.breached {
+			t.Errorf("IsBreached(%q): expected %v", c.password, c.breached)
+		}
+	}
+
+	empty := NewHashFile(strings.NewReader(""), 0)
+	breached, err := empty.IsBreached("password")
+	if err != nil || breached {
+		t.Errorf("Nothing should be breached in an empty list: %v", err)
+	}
+}
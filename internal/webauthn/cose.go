@@ -0,0 +1,145 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers from the IANA registry, in order of preference
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters (RFC 9052 section 7 and RFC 9053)
+const (
+	coseKeyType   = 1
+	coseAlgorithm = 3
+	coseCurve     = -1
+	coseX         = -2
+	coseY         = -3
+	coseN         = -1
+	coseE         = -2
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+// A credential public key decoded from its COSE_Key form
+type PublicKey struct {
+	Algorithm int
+	key       crypto.PublicKey
+}
+
+func coseInt(m map[any]any, label int64) (int64, bool) {
+	v, ok := m[label].(int64)
+	return v, ok
+}
+
+func coseBytes(m map[any]any, label int64) ([]byte, bool) {
+	v, ok := m[label].([]byte)
+	return v, ok
+}
+
+// Decodes a COSE_Key as stored for a credential
+func ParsePublicKey(data []byte) (PublicKey, error) {
+	v, rest, err := decodeCBOR(data)
+	if err != nil {
+		return PublicKey{}, fmt.Errorf("Invalid public key: %w", err)
+	}
+	if len(rest) > 0 {
+		return PublicKey{}, fmt.Errorf("Invalid public key: trailing data")
+	}
+
+	m, ok := v.(map[any]any)
+	if !ok {
+		return PublicKey{}, fmt.Errorf("Invalid public key: not a map")
+	}
+
+	keyType, _ := coseInt(m, coseKeyType)
+	alg, ok := coseInt(m, coseAlgorithm)
+	if !ok {
+		return PublicKey{}, fmt.Errorf("Public key has no algorithm")
+	}
+
+	switch {
+	case alg == AlgES256 && keyType == coseKeyTypeEC2:
+		curve, _ := coseInt(m, coseCurve)
+		x, okX := coseBytes(m, coseX)
+		y, okY := coseBytes(m, coseY)
+		if curve != coseCurveP256 || !okX || !okY || len(x) != 32 || len(y) != 32 {
+			return PublicKey{}, fmt.Errorf("Invalid P-256 public key")
+		}
+
+		// Checks the point is on the curve
+		point := append([]byte{4}, append(x, y...)...)
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return PublicKey{}, fmt.Errorf("Invalid P-256 public key: %w", err)
+		}
+		return PublicKey{Algorithm: AlgES256, key: key}, nil
+
+	case alg == AlgEdDSA && keyType == coseKeyTypeOKP:
+		curve, _ := coseInt(m, coseCurve)
+		x, ok := coseBytes(m, coseX)
+		if curve != coseCurveEd25519 || !ok || len(x) != ed25519.PublicKeySize {
+			return PublicKey{}, fmt.Errorf("Invalid Ed25519 public key")
+		}
+		return PublicKey{Algorithm: AlgEdDSA, key: ed25519.PublicKey(x)}, nil
+
+	case alg == AlgRS256 && keyType == coseKeyTypeRSA:
+		n, okN := coseBytes(m, coseN)
+		e, okE := coseBytes(m, coseE)
+		if !okN || !okE || len(e) == 0 || len(e) > 4 {
+			return PublicKey{}, fmt.Errorf("Invalid RSA public key")
+		}
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if key.N.BitLen() < 2048 {
+			return PublicKey{}, fmt.Errorf("RSA public key is too small")
+		}
+		return PublicKey{Algorithm: AlgRS256, key: key}, nil
+	}
+
+	return PublicKey{}, fmt.Errorf("Unsupported public key algorithm %v", alg)
+}
+
+// Checks a signature over data; ES256 signatures are ASN.1 DER encoded as
+// WebAuthn requires
+func (k PublicKey) Verify(data, signature []byte) error {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(key, hash[:], signature) {
+			return fmt.Errorf("Invalid signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, data, signature) {
+			return fmt.Errorf("Invalid signature")
+		}
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(data)
+		err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+		if err != nil {
+			return fmt.Errorf("Invalid signature: %w", err)
+		}
+	default:
+		return fmt.Errorf("Unsupported public key")
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const challengeLength = 32
+
+// Authenticator data flags (WebAuthn section 6.1)
+const (
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagBackupEligible   = 0x08
+	flagBackedUp         = 0x10
+	flagAttestedCredData = 0x40
+)
+
+// Returned when an authenticator's signature counter hasn't gone up, which
+// means the credential may have been cloned
+var ErrSignCountRegressed = errors.New("Signature counter did not increase")
+
+// The site credentials are scoped to
+// ID is the domain the credentials belong to and Origin is the exact origin,
+// such as https://chirpy.example, the browser reports the ceremony ran on
+type RelyingParty struct {
+	ID     string
+	Name   string
+	Origin string
+}
+
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, challengeLength)
+	_, err := rand.Read(challenge)
+	if err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// The base64url encoding WebAuthn uses for binary values in JSON
+func EncodeBase64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Also accepts padding, which some clients add
+func DecodeBase64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(string(bytes.TrimRight([]byte(s), "=")))
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// The challenge a client says it is answering, so the caller can look up
+// whether it was issued before verifying anything else
+func ClientDataChallenge(clientDataJSON []byte) ([]byte, error) {
+	data := clientData{}
+	err := json.Unmarshal(clientDataJSON, &data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid client data: %w", err)
+	}
+	return DecodeBase64(data.Challenge)
+}
+
+// WebAuthn section 7.1 steps 7-10 and 7.2 steps 11-14
+func (rp RelyingParty) verifyClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	data := clientData{}
+	err := json.Unmarshal(clientDataJSON, &data)
+	if err != nil {
+		return fmt.Errorf("Invalid client data: %w", err)
+	}
+
+	if data.Type != ceremony {
+		return fmt.Errorf("Expected %v but got %v", ceremony, data.Type)
+	}
+
+	received, err := DecodeBase64(data.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(received, challenge) != 1 {
+		return fmt.Errorf("Challenge doesn't match")
+	}
+
+	if data.Origin != rp.Origin {
+		return fmt.Errorf("Unexpected origin %v", data.Origin)
+	}
+
+	if data.CrossOrigin {
+		return fmt.Errorf("Cross-origin ceremonies aren't allowed")
+	}
+
+	return nil
+}
+
+type authenticatorData struct {
+	flags     byte
+	signCount uint32
+
+	// Only present when registering
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// Parses authenticator data and checks it is for this relying party and
+// that the user was present and verified
+func (rp RelyingParty) parseAuthenticatorData(data []byte) (authenticatorData, error) {
+	if len(data) < 37 {
+		return authenticatorData{}, fmt.Errorf("Authenticator data is too short")
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(data[:32], rpIDHash[:]) != 1 {
+		return authenticatorData{}, fmt.Errorf("Credential is for another relying party")
+	}
+
+	parsed := authenticatorData{
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	if parsed.flags&flagUserPresent == 0 {
+		return authenticatorData{}, fmt.Errorf("User wasn't present")
+	}
+
+	// Passkeys replace both the password and the second factor, so the
+	// authenticator must have checked a PIN or biometric
+	if parsed.flags&flagUserVerified == 0 {
+		return authenticatorData{}, fmt.Errorf("User wasn't verified")
+	}
+
+	if parsed.flags&flagBackedUp != 0 && parsed.flags&flagBackupEligible == 0 {
+		return authenticatorData{}, fmt.Errorf("Invalid backup flags")
+	}
+
+	rest := data[37:]
+
+	if parsed.flags&flagAttestedCredData != 0 {
+		if len(rest) < 18 {
+			return authenticatorData{}, fmt.Errorf("Attested credential data is too short")
+		}
+		parsed.aaguid = rest[:16]
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+
+		if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+			return authenticatorData{}, fmt.Errorf("Invalid credential ID")
+		}
+		parsed.credentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		// The key is followed by extensions if there are any, so its length
+		// is only known by decoding it
+		_, afterKey, err := decodeCBOR(rest)
+		if err != nil {
+			return authenticatorData{}, fmt.Errorf("Invalid credential public key: %w", err)
+		}
+		parsed.publicKey = rest[:len(rest)-len(afterKey)]
+	}
+
+	return parsed, nil
+}
+
+// A newly registered credential, to be stored with the user it belongs to
+type Credential struct {
+	ID []byte
+	// COSE_Key encoded, as ParsePublicKey takes it
+	PublicKey      []byte
+	SignCount      uint32
+	AAGUID         []byte
+	BackupEligible bool
+}
+
+// Verifies the response to navigator.credentials.create() for a challenge
+// issued to the user (WebAuthn section 7.1)
+// Attestation isn't checked, since Chirpy asks for none and doesn't restrict
+// which authenticators can be used
+func (rp RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) (Credential, error) {
+	err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	v, rest, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return Credential{}, fmt.Errorf("Invalid attestation object: %w", err)
+	}
+	if len(rest) > 0 {
+		return Credential{}, fmt.Errorf("Invalid attestation object: trailing data")
+	}
+
+	attestation, ok := v.(map[any]any)
+	if !ok {
+		return Credential{}, fmt.Errorf("Invalid attestation object: not a map")
+	}
+
+	authData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return Credential{}, fmt.Errorf("Attestation object has no authenticator data")
+	}
+
+	parsed, err := rp.parseAuthenticatorData(authData)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if parsed.credentialID == nil {
+		return Credential{}, fmt.Errorf("No credential was created")
+	}
+
+	_, err = ParsePublicKey(parsed.publicKey)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	return Credential{
+		ID:             parsed.credentialID,
+		PublicKey:      parsed.publicKey,
+		SignCount:      parsed.signCount,
+		AAGUID:         parsed.aaguid,
+		BackupEligible: parsed.flags&flagBackupEligible != 0,
+	}, nil
+}
+
+// Verifies the response to navigator.credentials.get() with the stored
+// public key and signature counter of the credential it names (WebAuthn
+// section 7.2), returning the new counter to store
+func (rp RelyingParty) VerifyAssertion(challenge, clientDataJSON, authenticatorData, signature,
+	publicKey []byte, storedSignCount uint32) (uint32, error) {
+	err := rp.verifyClientData(clientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	parsed, err := rp.parseAuthenticatorData(authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authenticatorData...), clientDataHash[:]...)
+
+	err = key.Verify(signed, signature)
+	if err != nil {
+		return 0, err
+	}
+
+	// Authenticators that don't keep a counter, like most synced passkeys,
+	// always report zero
+	if (parsed.signCount != 0 || storedSignCount != 0) && parsed.signCount <= storedSignCount {
+		return 0, ErrSignCountRegressed
+	}
+
+	return parsed.signCount, nil
+}
@@ -0,0 +1,348 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+)
+
+var testRP = RelyingParty{
+	ID:     "chirpy.example",
+	Name:   "Chirpy",
+	Origin: "https://chirpy.example",
+}
+
+// Encodes the values the software authenticator needs in canonical CBOR
+func encodeCBOR(v any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 1<<16:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		default:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+		}
+	}
+
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[any]any:
+		entries := [][2][]byte{}
+		for key, value := range v {
+			entries = append(entries, [2][]byte{encodeCBOR(key), encodeCBOR(value)})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i][0], entries[j][0]) < 0
+		})
+		out := head(5, uint64(len(v)))
+		for _, e := range entries {
+			out = append(append(out, e[0]...), e[1]...)
+		}
+		return out
+	}
+	panic("unsupported value")
+}
+
+// A software authenticator holding a single credential
+type softAuthenticator struct {
+	credentialID []byte
+	ecKey        *ecdsa.PrivateKey
+	edKey        ed25519.PrivateKey
+	signCount    uint32
+	flags        byte
+	// Like most synced passkeys, always reports a zero sign count
+	noCounter bool
+}
+
+func newSoftAuthenticator(t *testing.T, alg int) *softAuthenticator {
+	a := &softAuthenticator{
+		credentialID: make([]byte, 16),
+		flags:        flagUserPresent | flagUserVerified,
+	}
+	rand.Read(a.credentialID)
+
+	var err error
+	switch alg {
+	case AlgES256:
+		a.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, a.edKey, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatalf("Test is broken: %v", err)
+	}
+	return a
+}
+
+func (a *softAuthenticator) publicKey() []byte {
+	if a.ecKey != nil {
+		point, _ := a.ecKey.PublicKey.Bytes()
+		return encodeCBOR(map[any]any{
+			coseKeyType:   coseKeyTypeEC2,
+			coseAlgorithm: AlgES256,
+			coseCurve:     coseCurveP256,
+			coseX:         point[1:33],
+			coseY:         point[33:],
+		})
+	}
+	return encodeCBOR(map[any]any{
+		coseKeyType:   coseKeyTypeOKP,
+		coseAlgorithm: AlgEdDSA,
+		coseCurve:     coseCurveEd25519,
+		coseX:         []byte(a.edKey.Public().(ed25519.PublicKey)),
+	})
+}
+
+func (a *softAuthenticator) authData(rpID string, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+
+	flags := a.flags
+	if attested {
+		flags |= flagAttestedCredData
+	}
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.publicKey()...)
+	}
+	return data
+}
+
+func clientDataJSON(ceremony string, challenge []byte, origin string) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"type":      ceremony,
+		"challenge": EncodeBase64(challenge),
+		"origin":    origin,
+	})
+	return data
+}
+
+// navigator.credentials.create()
+func (a *softAuthenticator) create(rpID, origin string, challenge []byte) ([]byte, []byte) {
+	attestation := encodeCBOR(map[any]any{
+		"fmt":      "none",
+		"attStmt":  map[any]any{},
+		"authData": a.authData(rpID, true),
+	})
+	return clientDataJSON("webauthn.create", challenge, origin), attestation
+}
+
+// navigator.credentials.get()
+func (a *softAuthenticator) get(rpID, origin string, challenge []byte) ([]byte, []byte, []byte) {
+	if !a.noCounter {
+		a.signCount++
+	}
+
+	clientData := clientDataJSON("webauthn.get", challenge, origin)
+	authData := a.authData(rpID, false)
+	hash := sha256.Sum256(clientData)
+	signed := append(append([]byte{}, authData...), hash[:]...)
+
+	var signature []byte
+	if a.ecKey != nil {
+		digest := sha256.Sum256(signed)
+		signature, _ = ecdsa.SignASN1(rand.Reader, a.ecKey, digest[:])
+	} else {
+		signature = ed25519.Sign(a.edKey, signed)
+	}
+	return clientData, authData, signature
+}
+
+func TestCeremonies(t *testing.T) {
+	for _, alg := range []int{AlgES256, AlgEdDSA} {
+		a := newSoftAuthenticator(t, alg)
+
+		challenge, err := NewChallenge()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		clientData, attestation := a.create(testRP.ID, testRP.Origin, challenge)
+		credential, err := testRP.VerifyRegistration(challenge, clientData, attestation)
+		if err != nil {
+			t.Fatalf("%v: registration failed: %v", alg, err)
+		}
+
+		if !bytes.Equal(credential.ID, a.credentialID) || !bytes.Equal(credential.PublicKey, a.publicKey()) {
+			t.Errorf("%v: credential doesn't match the authenticator", alg)
+		}
+
+		received, err := ClientDataChallenge(clientData)
+		if err != nil || !bytes.Equal(received, challenge) {
+			t.Errorf("%v: challenge not found in client data: %v", alg, err)
+		}
+
+		signCount := credential.SignCount
+		for range 2 {
+			challenge, _ = NewChallenge()
+			clientData, authData, signature := a.get(testRP.ID, testRP.Origin, challenge)
+
+			signCount, err = testRP.VerifyAssertion(challenge, clientData, authData, signature,
+				credential.PublicKey, signCount)
+			if err != nil {
+				t.Fatalf("%v: assertion failed: %v", alg, err)
+			}
+			if signCount != a.signCount {
+				t.Errorf("%v: expected sign count %v but got %v", alg, a.signCount, signCount)
+			}
+		}
+	}
+}
+
+func TestRegistrationRejects(t *testing.T) {
+	challenge, _ := NewChallenge()
+	other, _ := NewChallenge()
+
+	tests := []struct {
+		name      string
+		rpID      string
+		origin    string
+		challenge []byte
+		flags     byte
+	}{
+		{"wrong challenge", testRP.ID, testRP.Origin, other, flagUserPresent | flagUserVerified},
+		{"wrong origin", testRP.ID, "https://evil.example", challenge, flagUserPresent | flagUserVerified},
+		{"wrong relying party", "evil.example", testRP.Origin, challenge, flagUserPresent | flagUserVerified},
+		{"user not verified", testRP.ID, testRP.Origin, challenge, flagUserPresent},
+		{"user not present", testRP.ID, testRP.Origin, challenge, flagUserVerified},
+	}
+
+	for _, tt := range tests {
+		a := newSoftAuthenticator(t, AlgES256)
+		a.flags = tt.flags
+
+		clientData, attestation := a.create(tt.rpID, tt.origin, tt.challenge)
+		_, err := testRP.VerifyRegistration(challenge, clientData, attestation)
+		if err == nil {
+			t.Errorf("%v: should be rejected", tt.name)
+		}
+	}
+
+	a := newSoftAuthenticator(t, AlgES256)
+	clientData, attestation := a.create(testRP.ID, testRP.Origin, challenge)
+	_, err := testRP.VerifyRegistration(challenge, clientData, attestation[:len(attestation)-5])
+	if err == nil {
+		t.Errorf("Truncated attestation object should be rejected")
+	}
+
+	// An assertion can't be used to register
+	clientData, authData, _ := a.get(testRP.ID, testRP.Origin, challenge)
+	attestation = encodeCBOR(map[any]any{"fmt": "none", "attStmt": map[any]any{}, "authData": authData})
+	_, err = testRP.VerifyRegistration(challenge, clientData, attestation)
+	if err == nil {
+		t.Errorf("Assertion client data should be rejected when registering")
+	}
+}
+
+func TestAssertionRejects(t *testing.T) {
+	a := newSoftAuthenticator(t, AlgES256)
+	challenge, _ := NewChallenge()
+
+	clientData, attestation := a.create(testRP.ID, testRP.Origin, challenge)
+	credential, err := testRP.VerifyRegistration(challenge, clientData, attestation)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	clientData, authData, signature := a.get(testRP.ID, testRP.Origin, challenge)
+
+	other := newSoftAuthenticator(t, AlgES256)
+	_, err = testRP.VerifyAssertion(challenge, clientData, authData, signature, other.publicKey(), 0)
+	if err == nil {
+		t.Errorf("Signature from another key should be rejected")
+	}
+
+	tampered := append([]byte{}, authData...)
+	tampered[len(tampered)-1]++
+	_, err = testRP.VerifyAssertion(challenge, clientData, tampered, signature, credential.PublicKey, 0)
+	if err == nil {
+		t.Errorf("Tampered authenticator data should be rejected")
+	}
+
+	wrongChallenge, _ := NewChallenge()
+	_, err = testRP.VerifyAssertion(wrongChallenge, clientData, authData, signature, credential.PublicKey, 0)
+	if err == nil {
+		t.Errorf("Wrong challenge should be rejected")
+	}
+
+	// A clone that fell behind the original
+	_, err = testRP.VerifyAssertion(challenge, clientData, authData, signature, credential.PublicKey, 5)
+	if !errors.Is(err, ErrSignCountRegressed) {
+		t.Errorf("Expected a sign count error but got %v", err)
+	}
+
+	// Authenticators without a counter always send zero
+	a = newSoftAuthenticator(t, AlgEdDSA)
+	a.noCounter = true
+	clientData, attestation = a.create(testRP.ID, testRP.Origin, challenge)
+	credential, err = testRP.VerifyRegistration(challenge, clientData, attestation)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for range 2 {
+		clientData, authData, signature = a.get(testRP.ID, testRP.Origin, challenge)
+		signCount, err := testRP.VerifyAssertion(challenge, clientData, authData, signature,
+			credential.PublicKey, credential.SignCount)
+		if err != nil || signCount != 0 {
+			t.Errorf("Zero sign counts should be accepted: %v", err)
+		}
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	v, rest, err := decodeCBOR(append(encodeCBOR(map[any]any{
+		1:   -7,
+		"a": []byte{1, 2},
+		-1:  "text",
+	}), 0xff))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(rest, []byte{0xff}) {
+		t.Errorf("Expected trailing bytes to be returned but got %v", rest)
+	}
+
+	m := v.(map[any]any)
+	if m[int64(1)] != int64(-7) || !bytes.Equal(m["a"].([]byte), []byte{1, 2}) || m[int64(-1)] != "text" {
+		t.Errorf("Unexpected map: %v", m)
+	}
+
+	for _, data := range [][]byte{
+		{0x5f},             // indefinite length byte string
+		{0x43, 1, 2},       // truncated byte string
+		{0xfa, 0, 0, 0, 0}, // float
+		{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, // huge array
+		{0xa2, 0x01, 0x01, 0x01, 0x02},                         // duplicate key
+		bytes.Repeat([]byte{0x81}, maxCBORDepth+2),
+	} {
+		_, _, err := decodeCBOR(data)
+		if err == nil {
+			t.Errorf("%x should be rejected", data)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/auth"
+)
+
+// Builds the JWT keyring from the environment:
+// JWT_SIGNING_KEY_FILE is a PEM Ed25519 or RSA private key that signs new
+// tokens. JWT_RETIRED_KEY_FILES is a comma separated list of PEM keys that
+// only verify, each a path or kid=path to keep the key ID it signed with.
+// JWT_SECRET is the original HMAC secret; it signs new tokens when there is
+// no signing key file and otherwise only verifies. JWT_RETIRED_SECRETS is a
+// comma separated list of HMAC secrets that only verify.
+// JWT_ISSUER and JWT_AUDIENCE override the iss and aud claims
+func loadJWTKeys() (*auth.Keyring, error) {
+	keys := auth.NewKeyring()
+
+	issuer := os.Getenv("JWT_ISSUER")
+	if issuer != "" {
+		keys.Issuer = issuer
+	}
+
+	audience := os.Getenv("JWT_AUDIENCE")
+	if audience != "" {
+		keys.Audience = audience
+	}
+
+	signingKeyFile := os.Getenv("JWT_SIGNING_KEY_FILE")
+	secret := os.Getenv("JWT_SECRET")
+
+	if signingKeyFile != "" {
+		key, err := auth.LoadKeyFile(os.Getenv("JWT_SIGNING_KEY_ID"), signingKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		err = keys.Add(key, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if secret != "" {
+		key, err := auth.ParseHMACSecret(secret)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid JWT_SECRET: %w", err)
+		}
+		err = keys.Add(key, signingKeyFile == "")
+		if err != nil {
+			return nil, err
+		}
+		keys.SetLegacyKey(key)
+	}
+
+	if signingKeyFile == "" && secret == "" {
+		return nil, fmt.Errorf("Either JWT_SIGNING_KEY_FILE or JWT_SECRET must be set")
+	}
+
+	retired := os.Getenv("JWT_RETIRED_KEY_FILES")
+	for _, spec := range strings.Split(retired, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		key, err := auth.LoadKeyFileSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		err = keys.Add(key, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	retiredSecrets := os.Getenv("JWT_RETIRED_SECRETS")
+	for _, retiredSecret := range strings.Split(retiredSecrets, ",") {
+		retiredSecret = strings.TrimSpace(retiredSecret)
+		if retiredSecret == "" {
+			continue
+		}
+		key, err := auth.ParseHMACSecret(retiredSecret)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid JWT_RETIRED_SECRETS: %w", err)
+		}
+		err = keys.Add(key, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// Publishes the public halves of the signing keys so other services can
+// verify tokens
+func (cfg *apiConfig) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	res, err := chirpyEncodeJsonResponse(200, cfg.jwtKeys.JWKS())
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	chirpySendResponse(w, res)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/auth"
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+)
+
+func (cfg *apiConfig) chirpLikeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, auth.ScopeChirpsWrite)
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "Chirp not found", err)
+		return
+	}
+
+	dbChirpRow, err := cfg.dbQueries.GetChirpByID(r.Context(), chirpID)
+	if err != nil || dbChirpRow.DeletedAt.Valid ||
+		chirpHiddenFrom(dbChirpRow, uuid.NullUUID{UUID: userID, Valid: true}) {
+		chirpySendErrorResponse(w, 404, "Chirp not found", err)
+		return
+	}
+
+	err = cfg.dbQueries.LikeChirp(r.Context(),
+		database.LikeChirpParams{
+			ChirpID: chirpID,
+			UserID:  userID,
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to like chirp", err)
+		return
+	}
+
+	w.WriteHeader(204)
+	w.Write([]byte{})
+}
+
+func (cfg *apiConfig) chirpUnlikeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, auth.ScopeChirpsWrite)
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "Chirp not found", err)
+		return
+	}
+
+	err = cfg.dbQueries.UnlikeChirp(r.Context(),
+		database.UnlikeChirpParams{
+			ChirpID: chirpID,
+			UserID:  userID,
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to unlike chirp", err)
+		return
+	}
+
+	w.WriteHeader(204)
+	w.Write([]byte{})
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/auth"
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+)
+
+// Failures are counted against the email address tried and against the
+// client's IP. An IP gets more leeway since many users can share one
+var loginBackoffPolicies = map[string]auth.BackoffPolicy{
+	"account": {
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: 30 * time.Minute,
+	},
+	"ip": {
+		FreeAttempts:    20,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Minute,
+		LockoutAfter:    100,
+		LockoutDuration: time.Hour,
+	},
+}
+
+type loginKey struct {
+	Kind string
+	Key  string
+}
+
+// Keys by address rather than user so unknown addresses are throttled the
+// same way and lockouts don't reveal which ones have accounts
+func loginAccountKey(email string) loginKey {
+	return loginKey{Kind: "account", Key: strings.ToLower(strings.TrimSpace(email))}
+}
+
+func loginKeys(r *http.Request, email string) []loginKey {
+	return []loginKey{
+		loginAccountKey(email),
+		{Kind: "ip", Key: clientIP(r)},
+	}
+}
+
+// Lets a login attempt through before the password or code is checked,
+// returning how long until one may be attempted again if it can't be now
+// Every attempt let through should end in recordLoginFailure or
+// releaseLoginAttempt; ones that don't stop counting after a minute
+func (cfg *apiConfig) reserveLoginAttempt(ctx context.Context, keys []loginKey) (time.Duration, error) {
+	for i, key := range keys {
+		policy := loginBackoffPolicies[key.Kind]
+
+		_, err := cfg.dbQueries.ReserveLoginAttempt(ctx,
+			database.ReserveLoginAttemptParams{
+				Kind:         key.Kind,
+				Key:          key.Key,
+				FreeAttempts: int32(policy.FreeAttempts),
+			})
+		if err == nil {
+			continue
+		}
+
+		releaseErr := cfg.releaseLoginAttempt(ctx, keys[:i])
+		if releaseErr != nil {
+			log.Printf("Error: %v", releaseErr)
+		}
+
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("Failed to reserve login attempt: %w", err)
+		}
+
+		// Either locked, or waiting on an attempt that is still in flight
+		retryAfter := policy.BaseDelay
+		dbFailure, err := cfg.dbQueries.GetLoginFailure(ctx,
+			database.GetLoginFailureParams{
+				Kind: key.Kind,
+				Key:  key.Key,
+			})
+		if err == nil && dbFailure.LockedUntil.Valid {
+			retryAfter = max(retryAfter, time.Until(dbFailure.LockedUntil.Time))
+		}
+		return retryAfter, nil
+	}
+
+	return 0, nil
+}
+
+// Ends an attempt that didn't fail
+func (cfg *apiConfig) releaseLoginAttempt(ctx context.Context, keys []loginKey) error {
+	for _, key := range keys {
+		err := cfg.dbQueries.ReleaseLoginAttempt(ctx,
+			database.ReleaseLoginAttemptParams{
+				Kind: key.Kind,
+				Key:  key.Key,
+			})
+		if err != nil {
+			return fmt.Errorf("Failed to release login attempt: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Counts a failed password or code and holds off further attempts according
+// to the backoff policies
+func (cfg *apiConfig) recordLoginFailure(ctx context.Context, keys []loginKey) error {
+	for _, key := range keys {
+		dbFailure, err := cfg.dbQueries.RecordLoginFailure(ctx,
+			database.RecordLoginFailureParams{
+				Kind: key.Kind,
+				Key:  key.Key,
+			})
+		if err != nil {
+			return fmt.Errorf("Failed to record login failure: %w", err)
+		}
+
+		policy := loginBackoffPolicies[key.Kind]
+		failures := int(dbFailure.Failures)
+
+		delay := policy.Delay(failures)
+		if delay == 0 {
+			continue
+		}
+
+		if policy.LockedOut(failures) {
+			log.Printf("Locking out %v %v for %v after %v failed logins",
+				key.Kind, key.Key, delay, failures)
+		}
+
+		err = cfg.dbQueries.LockLogin(ctx,
+			database.LockLoginParams{
+				Kind:        key.Kind,
+				Key:         key.Key,
+				LockedUntil: sql.NullTime{Time: time.Now().Add(delay), Valid: true},
+			})
+		if err != nil {
+			return fmt.Errorf("Failed to lock login: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Forgets an account's failures after a successful login
+// The IP's failures stay so logging in to one account can't reset the
+// count for guesses against others
+func (cfg *apiConfig) clearLoginFailures(ctx context.Context, email string) error {
+	key := loginAccountKey(email)
+	_, err := cfg.dbQueries.ClearLoginFailures(ctx,
+		database.ClearLoginFailuresParams{
+			Kind: key.Kind,
+			Key:  key.Key,
+		})
+	if err != nil {
+		return fmt.Errorf("Failed to clear login failures: %w", err)
+	}
+
+	// Keeps old failures from piling up
+	_, err = cfg.dbQueries.DeleteStaleLoginFailures(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to delete stale login failures: %w", err)
+	}
+
+	return nil
+}
+
+func sendTooManyLoginAttempts(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", fmt.Sprint(seconds))
+	chirpySendErrorResponse(w, 429, "Too many failed login attempts", nil)
+}
+
+type loginLockout struct {
+	Kind         string `json:"kind"`
+	Key          string `json:"key"`
+	Failures     int32  `json:"failures"`
+	LastFailedAt string `json:"last_failed_at"`
+	LockedUntil  string `json:"locked_until"`
+}
+
+func (cfg *apiConfig) loginLockoutsGetHandler(w http.ResponseWriter, r *http.Request) {
+	dbFailures, err := cfg.dbQueries.ListLoginLockouts(r.Context())
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get lockouts", err)
+		return
+	}
+
+	response := []loginLockout{}
+	for _, f := range dbFailures {
+		response = append(response, loginLockout{
+			Kind:         f.Kind,
+			Key:          f.Key,
+			Failures:     f.Failures,
+			LastFailedAt: f.LastFailedAt.String(),
+			LockedUntil:  f.LockedUntil.Time.String(),
+		})
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+// Lifts a lockout and forgets the failures behind it
+// kind is "account" with an email address as the key, or "ip"
+func (cfg *apiConfig) loginLockoutDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	kind := r.PathValue("kind")
+	_, ok := loginBackoffPolicies[kind]
+	if !ok {
+		chirpySendErrorResponse(w, 404, "Lockout not found", nil)
+		return
+	}
+
+	key := r.PathValue("key")
+	if kind == "account" {
+		key = loginAccountKey(key).Key
+	}
+
+	rows, err := cfg.dbQueries.ClearLoginFailures(r.Context(),
+		database.ClearLoginFailuresParams{
+			Kind: kind,
+			Key:  key,
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to clear lockout", err)
+		return
+	}
+
+	if rows == 0 {
+		chirpySendErrorResponse(w, 404, "Lockout not found", nil)
+		return
+	}
+
+	w.WriteHeader(204)
+	w.Write([]byte{})
+}
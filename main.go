@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,7 +11,6 @@ import (
 	"strings"
 	"sync/atomic"
 	"time"
-	"sort"
 
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
@@ -17,15 +18,38 @@ import (
 
 	"github.com/Tavis7/bootdev-chirpy/internal/auth"
 	"github.com/Tavis7/bootdev-chirpy/internal/database"
+	"github.com/Tavis7/bootdev-chirpy/internal/mailer"
+	"github.com/Tavis7/bootdev-chirpy/internal/moderation"
+	"github.com/Tavis7/bootdev-chirpy/internal/oidc"
+	"github.com/Tavis7/bootdev-chirpy/internal/passwordpolicy"
+	"github.com/Tavis7/bootdev-chirpy/internal/webauthn"
 )
 
 type apiConfig struct {
 	fileserverHits atomic.Int32
 	dbQueries      *database.Queries
 	isDevPlatform  bool
-	jwtSecret      string
+	jwtKeys        *auth.Keyring
 	jwtDuration time.Duration
 	polkaApiKey string
+
+	mailer    mailer.Mailer
+	publicURL string
+
+	passwordPolicy passwordpolicy.Policy
+
+	// Nil unless logging in with an OpenID Connect provider is configured
+	oidcProvider *oidc.Provider
+	relyingParty webauthn.RelyingParty
+
+	// Zero when deleted accounts are deleted right away
+	accountDeletionGracePeriod time.Duration
+
+	// Rules from MODERATION_RULES_FILE or the defaults, which rules stored
+	// in the database are layered on top of
+	baseModerationRules []moderation.Rule
+	moderationRules     *moderation.WordFilter
+	contentFilter       moderation.Filter
 }
 
 func main() {
@@ -46,11 +70,83 @@ func main() {
 	}
 	cfg.dbQueries = database.New(db)
 
-	cfg.jwtSecret = os.Getenv("JWT_SECRET")
+	cfg.jwtKeys, err = loadJWTKeys()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
 	cfg.jwtDuration = time.Hour * 1
 
+	passwordParams, err := loadPasswordParams()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	err = auth.SetPasswordParams(passwordParams)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	benchmarkPasswordParams(passwordParams)
+
+	cfg.passwordPolicy, err = loadPasswordPolicy()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
 	cfg.polkaApiKey = os.Getenv("POLKA_API_KEY")
 
+	cfg.mailer, err = loadMailer(cfg.isDevPlatform)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	cfg.publicURL = strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+	if cfg.publicURL == "" {
+		cfg.publicURL = "http://localhost:8080"
+	}
+
+	cfg.oidcProvider, err = loadOIDCProvider(cfg.publicURL)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	cfg.relyingParty, err = loadRelyingParty(cfg.publicURL)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	cfg.accountDeletionGracePeriod, err = loadAccountDeletionGracePeriod()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	cfg.baseModerationRules = moderation.DefaultRules()
+	rulesFile := os.Getenv("MODERATION_RULES_FILE")
+	if rulesFile != "" {
+		cfg.baseModerationRules, err = loadModerationRulesFile(rulesFile)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+	}
+
+	cfg.moderationRules = moderation.NewWordFilter(cfg.baseModerationRules)
+	cfg.contentFilter = moderation.Chain{cfg.moderationRules}
+
+	err = cfg.reloadModerationRules(context.Background())
+	if err != nil {
+		fmt.Printf("Warning: using configured moderation rules only: %v\n", err)
+	}
+
+	cfg.bootstrapAdmin(context.Background())
+
+	go cfg.purgeDeletedUsers(context.Background())
+
 	fmt.Println("Starting server")
 	fmt.Printf("DB url: %v\n", dbUrl)
 	fmt.Printf("DB queries: %v\n", cfg.dbQueries)
@@ -59,21 +155,84 @@ func main() {
 
 	serveMux.Handle("GET /api/chirps", http.HandlerFunc(cfg.chirpsGetHandler))
 	serveMux.Handle("POST /api/chirps", http.HandlerFunc(cfg.chirpCreateHandler))
+	serveMux.Handle("GET /api/chirps/search", http.HandlerFunc(cfg.chirpsSearchHandler))
 	serveMux.Handle("GET /api/chirps/{id}", http.HandlerFunc(cfg.chirpGetHandler))
+	serveMux.Handle("PUT /api/chirps/{id}", http.HandlerFunc(cfg.chirpEditHandler))
 	serveMux.Handle("DELETE /api/chirps/{id}", http.HandlerFunc(cfg.chirpDeleteHandler))
+	serveMux.Handle("GET /api/chirps/{id}/revisions", http.HandlerFunc(cfg.chirpRevisionsGetHandler))
+	serveMux.Handle("GET /api/chirps/{id}/replies", http.HandlerFunc(cfg.chirpRepliesGetHandler))
+	serveMux.Handle("GET /api/chirps/{id}/thread", http.HandlerFunc(cfg.chirpThreadGetHandler))
+	serveMux.Handle("PUT /api/chirps/{id}/like", http.HandlerFunc(cfg.chirpLikeHandler))
+	serveMux.Handle("DELETE /api/chirps/{id}/like", http.HandlerFunc(cfg.chirpUnlikeHandler))
+	serveMux.Handle("POST /api/chirps/{id}/report", http.HandlerFunc(cfg.chirpReportHandler))
 
 	serveMux.Handle("POST /api/users", http.HandlerFunc(cfg.userCreateHandler))
 	serveMux.Handle("PUT /api/users", http.HandlerFunc(cfg.userModifyHandler))
+	serveMux.Handle("DELETE /api/users", http.HandlerFunc(cfg.userDeleteHandler))
+	serveMux.Handle("GET /api/users/me/export", http.HandlerFunc(cfg.userExportHandler))
+	serveMux.Handle("GET /api/users/{id}", http.HandlerFunc(cfg.userGetHandler))
+	serveMux.Handle("GET /api/users/by-handle/{handle}", http.HandlerFunc(cfg.userGetByHandleHandler))
+	serveMux.Handle("POST /api/users/{id}/follow", http.HandlerFunc(cfg.followCreateHandler))
+	serveMux.Handle("DELETE /api/users/{id}/follow", http.HandlerFunc(cfg.followDeleteHandler))
+	serveMux.Handle("GET /api/users/{id}/{list}", http.HandlerFunc(cfg.followListGetHandler))
+	serveMux.Handle("GET /api/timeline", http.HandlerFunc(cfg.timelineGetHandler))
 
 	serveMux.Handle("POST /api/login", http.HandlerFunc(cfg.userLoginHandler))
+	serveMux.Handle("POST /api/login/mfa", http.HandlerFunc(cfg.loginMFAHandler))
+	serveMux.Handle("GET /api/oidc/login", http.HandlerFunc(cfg.oidcLoginHandler))
+	serveMux.Handle("GET /api/oidc/callback", http.HandlerFunc(cfg.oidcCallbackHandler))
+	serveMux.Handle("POST /api/login/passkey/begin", http.HandlerFunc(cfg.passkeyLoginBeginHandler))
+	serveMux.Handle("POST /api/login/passkey/finish", http.HandlerFunc(cfg.passkeyLoginFinishHandler))
+	serveMux.Handle("POST /api/passkeys/register/begin", http.HandlerFunc(cfg.passkeyRegisterBeginHandler))
+	serveMux.Handle("POST /api/passkeys/register/finish", http.HandlerFunc(cfg.passkeyRegisterFinishHandler))
+	serveMux.Handle("GET /api/passkeys", http.HandlerFunc(cfg.passkeysGetHandler))
+	serveMux.Handle("DELETE /api/passkeys/{id}", http.HandlerFunc(cfg.passkeyDeleteHandler))
+	serveMux.Handle("POST /api/mfa/totp/enroll", http.HandlerFunc(cfg.totpEnrollHandler))
+	serveMux.Handle("POST /api/mfa/totp/confirm", http.HandlerFunc(cfg.totpConfirmHandler))
+	serveMux.Handle("DELETE /api/mfa/totp", http.HandlerFunc(cfg.totpDisableHandler))
+	serveMux.Handle("POST /api/password-reset/request", http.HandlerFunc(cfg.passwordResetRequestHandler))
+	serveMux.Handle("POST /api/password-reset/confirm", http.HandlerFunc(cfg.passwordResetConfirmHandler))
+	serveMux.Handle("POST /api/email/verify", http.HandlerFunc(cfg.emailVerifyHandler))
+	serveMux.Handle("POST /api/email/verify/resend", http.HandlerFunc(cfg.emailVerifyResendHandler))
 	serveMux.Handle("POST /api/refresh", http.HandlerFunc(cfg.userAuthRefreshHandler))
 	serveMux.Handle("POST /api/revoke", http.HandlerFunc(cfg.userAuthRevokeHandler))
+	serveMux.Handle("POST /api/logout", http.HandlerFunc(cfg.logoutHandler))
+	serveMux.Handle("GET /api/sessions", http.HandlerFunc(cfg.sessionsGetHandler))
+	serveMux.Handle("DELETE /api/sessions/{id}", http.HandlerFunc(cfg.sessionDeleteHandler))
+	serveMux.Handle("POST /api/sessions/revoke-all", http.HandlerFunc(cfg.sessionsRevokeAllHandler))
+
+	serveMux.Handle("POST /api/tokens", http.HandlerFunc(cfg.personalAccessTokenCreateHandler))
+	serveMux.Handle("GET /api/tokens", http.HandlerFunc(cfg.personalAccessTokensGetHandler))
+	serveMux.Handle("DELETE /api/tokens/{id}", http.HandlerFunc(cfg.personalAccessTokenDeleteHandler))
+
+	serveMux.Handle("POST /api/oauth/clients", http.HandlerFunc(cfg.oauthClientCreateHandler))
+	serveMux.Handle("GET /api/oauth/clients", http.HandlerFunc(cfg.oauthClientsGetHandler))
+	serveMux.Handle("DELETE /api/oauth/clients/{id}", http.HandlerFunc(cfg.oauthClientDeleteHandler))
+	serveMux.Handle("GET /oauth/authorize", http.HandlerFunc(cfg.oauthAuthorizeHandler))
+	serveMux.Handle("POST /oauth/authorize", http.HandlerFunc(cfg.oauthAuthorizeSubmitHandler))
+	serveMux.Handle("POST /oauth/token", http.HandlerFunc(cfg.oauthTokenHandler))
+	serveMux.Handle("POST /oauth/revoke", http.HandlerFunc(cfg.oauthRevokeHandler))
+	serveMux.Handle("POST /oauth/introspect", http.HandlerFunc(cfg.oauthIntrospectHandler))
 
 	serveMux.Handle("POST /api/polka/webhooks", http.HandlerFunc(cfg.upgradeUserToChirpyRedHandler))
 	serveMux.Handle("GET /api/healthz", http.HandlerFunc(healthHandler))
-
-	serveMux.Handle("GET /admin/metrics", http.HandlerFunc(cfg.getStatsHandler))
-	serveMux.Handle("POST /admin/reset", http.HandlerFunc(cfg.resetHandler))
+	serveMux.Handle("GET /.well-known/jwks.json", http.HandlerFunc(cfg.jwksHandler))
+
+	serveMux.Handle("GET /admin/metrics", cfg.requireRole(auth.RoleAdmin, cfg.getStatsHandler))
+	serveMux.Handle("POST /admin/reset", cfg.requireRole(auth.RoleAdmin, cfg.resetHandler))
+	serveMux.Handle("GET /admin/moderation/rules", cfg.requireRole(auth.RoleModerator, cfg.moderationRulesGetHandler))
+	serveMux.Handle("PUT /admin/moderation/rules/{word}", cfg.requireRole(auth.RoleModerator, cfg.moderationRuleSetHandler))
+	serveMux.Handle("DELETE /admin/moderation/rules/{word}", cfg.requireRole(auth.RoleModerator, cfg.moderationRuleDeleteHandler))
+	serveMux.Handle("GET /admin/reports", cfg.requireRole(auth.RoleModerator, cfg.reportsGetHandler))
+	serveMux.Handle("GET /admin/reports/{id}", cfg.requireRole(auth.RoleModerator, cfg.reportGetHandler))
+	serveMux.Handle("POST /admin/reports/{id}/resolve", cfg.requireRole(auth.RoleModerator, cfg.reportResolveHandler))
+	serveMux.Handle("GET /admin/lockouts", cfg.requireRole(auth.RoleAdmin, cfg.loginLockoutsGetHandler))
+	serveMux.Handle("DELETE /admin/lockouts/{kind}/{key}", cfg.requireRole(auth.RoleAdmin, cfg.loginLockoutDeleteHandler))
+	serveMux.Handle("GET /admin/staff", cfg.requireRole(auth.RoleAdmin, cfg.staffGetHandler))
+	serveMux.Handle("PUT /admin/users/{id}/role", cfg.requireRole(auth.RoleAdmin, cfg.userRoleSetHandler))
+	serveMux.Handle("POST /admin/chirps/{id}/hide", cfg.requireRole(auth.RoleModerator, cfg.chirpHideHandler))
+	serveMux.Handle("POST /admin/chirps/{id}/unhide", cfg.requireRole(auth.RoleModerator, cfg.chirpUnhideHandler))
+	serveMux.Handle("DELETE /admin/chirps/{id}", cfg.requireRole(auth.RoleModerator, cfg.chirpRemoveHandler))
 
 	serveMux.Handle("/app/", cfg.middlewareMetricsInc(
 		http.StripPrefix("/app/", http.FileServer(http.Dir(".")))))
@@ -111,12 +270,8 @@ func (cfg *apiConfig) getStatsHandler(w http.ResponseWriter, r *http.Request) {
 		cfg.fileserverHits.Load())))
 }
 
+// Deletes every user and chirp; only admins can reach it
 func (cfg *apiConfig) resetHandler(w http.ResponseWriter, r *http.Request) {
-	if !cfg.isDevPlatform {
-		chirpySendErrorResponse(w, 403, "Not a dev environment", nil)
-		return
-	}
-
 	_, err := cfg.dbQueries.ResetUsers(r.Context())
 	if err != nil {
 		chirpySendErrorResponse(w, 500, "Failed to delete users", err)
@@ -151,9 +306,30 @@ type chirpyUserInfo struct {
 	CreatedAt string `json:"created_at"`
 	UpdatedAt string `json:"updated_at"`
 	Email     string `json:"email"`
+	EmailVerified bool `json:"email_verified"`
 	Token     string `json:"token,omitempty"`
 	RefreshToken string `json:"refresh_token"`
 	IsChirpyRed bool `json:"is_chirpy_red"`
+	Role        string `json:"role"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url"`
+}
+
+// Fields left out of the request are not changed
+type userUpdateRequest struct {
+	Email       *string `json:"email"`
+	Password    *string `json:"password"`
+	Handle      *string `json:"handle"`
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	AvatarURL   *string `json:"avatar_url"`
+
+	// Proves who is asking before the email or password changes
+	CurrentPassword string `json:"current_password"`
+	Code            string `json:"code"`
+	RecoveryCode    string `json:"recovery_code"`
 }
 
 func (cfg *apiConfig) userCreateHandler(w http.ResponseWriter, r *http.Request) {
@@ -165,8 +341,7 @@ func (cfg *apiConfig) userCreateHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if len(req.Password) == 0 {
-		chirpySendErrorResponse(w, 400, "Password required", err)
+	if !cfg.checkPasswordPolicy(w, req.Password) {
 		return
 	}
 
@@ -194,12 +369,25 @@ func (cfg *apiConfig) userCreateHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// The account works without a verified address, so a mail failure
+	// doesn't fail signup; the user can ask for another link
+	err = cfg.sendEmailVerification(r.Context(), dbUserRow)
+	if err != nil {
+		log.Printf("Error: %v", err)
+	}
+
 	createdUser := chirpyUserInfo{
 		Id:        dbUserRow.ID.String(),
 		CreatedAt: dbUserRow.CreatedAt.String(),
 		UpdatedAt: dbUserRow.UpdatedAt.String(),
 		Email:     dbUserRow.Email,
+		EmailVerified: dbUserRow.EmailVerifiedAt.Valid,
 		IsChirpyRed: dbUserRow.IsChirpyRed,
+		Role:        dbUserRow.Role,
+		Handle:      dbUserRow.Handle,
+		DisplayName: dbUserRow.DisplayName,
+		Bio:         dbUserRow.Bio,
+		AvatarURL:   dbUserRow.AvatarUrl,
 	}
 
 	res, err := chirpyEncodeJsonResponse(201, createdUser)
@@ -212,17 +400,11 @@ func (cfg *apiConfig) userCreateHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (cfg *apiConfig) userModifyHandler(w http.ResponseWriter, r *http.Request) {
-	req := userAuthInfo{}
+	req := userUpdateRequest{}
 
-	token, err := auth.GetBearerToken(r.Header)
+	userID, err := cfg.authenticate(r, auth.ScopeProfileWrite)
 	if err != nil {
-		chirpySendErrorResponse(w, 401, "Authorization failed", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		chirpySendErrorResponse(w, 401, "Authorization failed", err)
+		sendAuthenticationError(w, err)
 		return
 	}
 
@@ -232,28 +414,91 @@ func (cfg *apiConfig) userModifyHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if len(req.Password) == 0 {
-		chirpySendErrorResponse(w, 400, "Password required", err)
-		return
+	// Taking over an account needs more than a token handed to a script or
+	// another app
+	if req.Email != nil || req.Password != nil {
+		_, err = cfg.authenticate(r, "")
+		if err != nil {
+			chirpySendErrorResponse(w, 403, "Email and password can only be changed after logging in", err)
+			return
+		}
+
+		// Nor can a stolen login token move the account to another address
+		// or password on its own
+		dbUser, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+		if err != nil {
+			chirpySendErrorResponse(w, 401, "Authorization failed", err)
+			return
+		}
+
+		if !cfg.reauthenticate(w, r, dbUser, req.CurrentPassword, req.Code, req.RecoveryCode) {
+			return
+		}
 	}
 
-	if len(req.Email) == 0 {
-		chirpySendErrorResponse(w, 400, "Email required", err)
-		return
+	params := database.UpdateUserParams{
+		ID: userID,
 	}
 
-	passwordHash, err := auth.HashPassword(req.Password)
-	if err != nil {
-		chirpySendErrorResponse(w, 500, "Failed to update user", err)
-		return
+	if req.Email != nil {
+		if len(*req.Email) == 0 {
+			chirpySendErrorResponse(w, 400, "Email required", nil)
+			return
+		}
+		params.Email = sql.NullString{String: *req.Email, Valid: true}
 	}
 
-	dbUserRow, err := cfg.dbQueries.UpdateUserEmailAndPassword(r.Context(),
-		database.UpdateUserEmailAndPasswordParams{
-			userID,
-			req.Email,
-			passwordHash,
-		})
+	if req.Password != nil {
+		if !cfg.checkPasswordPolicy(w, *req.Password) {
+			return
+		}
+
+		passwordHash, err := auth.HashPassword(*req.Password)
+		if err != nil {
+			chirpySendErrorResponse(w, 500, "Failed to update user", err)
+			return
+		}
+		params.HashedPassword = sql.NullString{String: passwordHash, Valid: true}
+	}
+
+	if req.Handle != nil {
+		handle, err := parseHandle(*req.Handle)
+		if err != nil {
+			chirpySendErrorResponse(w, 400, "Invalid handle", err)
+			return
+		}
+		params.Handle = sql.NullString{String: handle, Valid: true}
+	}
+
+	if req.DisplayName != nil {
+		displayName := strings.TrimSpace(*req.DisplayName)
+		err = validateDisplayName(displayName)
+		if err != nil {
+			chirpySendErrorResponse(w, 400, "Display name is too long", err)
+			return
+		}
+		params.DisplayName = sql.NullString{String: displayName, Valid: true}
+	}
+
+	if req.Bio != nil {
+		err = validateBio(*req.Bio)
+		if err != nil {
+			chirpySendErrorResponse(w, 400, "Bio is too long", err)
+			return
+		}
+		params.Bio = sql.NullString{String: *req.Bio, Valid: true}
+	}
+
+	if req.AvatarURL != nil {
+		err = validateAvatarURL(*req.AvatarURL)
+		if err != nil {
+			chirpySendErrorResponse(w, 400, "Invalid avatar URL", err)
+			return
+		}
+		params.AvatarUrl = sql.NullString{String: *req.AvatarURL, Valid: true}
+	}
+
+	dbUserRow, err := cfg.dbQueries.UpdateUser(r.Context(), params)
 	if err != nil {
 		e, ok := err.(*pq.Error)
 		if ok &&
@@ -263,16 +508,71 @@ func (cfg *apiConfig) userModifyHandler(w http.ResponseWriter, r *http.Request)
 			chirpySendErrorResponse(w, 400, "User already exists", e)
 			return
 		}
-		chirpySendErrorResponse(w, 500, "Error creating user", e)
+		if ok &&
+			e.Code.Name() == "unique_violation" &&
+			e.Constraint == "users_handle_key" {
+
+			chirpySendErrorResponse(w, 400, "Handle already taken", e)
+			return
+		}
+		chirpySendErrorResponse(w, 500, "Failed to update user", err)
 		return
 	}
 
+	if req.Email != nil || req.Password != nil {
+		err = cfg.deletePasswordResetTokens(r.Context(), userID)
+		if err != nil {
+			chirpySendErrorResponse(w, 500, "Failed to update user", err)
+			return
+		}
+	}
+
+	// Changing the address clears its verification in the same update
+	if req.Email != nil && !dbUserRow.EmailVerifiedAt.Valid {
+		err = cfg.sendEmailVerification(r.Context(), dbUserRow)
+		if err != nil {
+			log.Printf("Error: %v", err)
+		}
+	}
+
+	// A new password logs out every other device; this one gets new tokens
+	newToken := ""
+	newRefreshToken := ""
+
+	if req.Password != nil {
+		err = cfg.revokeUserTokens(r.Context(), userID)
+		if err != nil {
+			chirpySendErrorResponse(w, 500, "Failed to update user", err)
+			return
+		}
+
+		newToken, err = cfg.jwtKeys.MakeJWTWithRole(userID, dbUserRow.Role, cfg.jwtDuration)
+		if err != nil {
+			chirpySendErrorResponse(w, 500, "Failed to generate auth token", err)
+			return
+		}
+
+		newRefreshToken, err = cfg.issueRefreshToken(r, userID)
+		if err != nil {
+			chirpySendErrorResponse(w, 500, "Failed to generate refresh token", err)
+			return
+		}
+	}
+
 	updatedUser := chirpyUserInfo{
 		Id:        dbUserRow.ID.String(),
 		CreatedAt: dbUserRow.CreatedAt.String(),
 		UpdatedAt: dbUserRow.UpdatedAt.String(),
 		Email:     dbUserRow.Email,
+		EmailVerified: dbUserRow.EmailVerifiedAt.Valid,
+		Token:     newToken,
+		RefreshToken: newRefreshToken,
 		IsChirpyRed: dbUserRow.IsChirpyRed,
+		Role:        dbUserRow.Role,
+		Handle:      dbUserRow.Handle,
+		DisplayName: dbUserRow.DisplayName,
+		Bio:         dbUserRow.Bio,
+		AvatarURL:   dbUserRow.AvatarUrl,
 	}
 
 	res, err := chirpyEncodeJsonResponse(200, updatedUser)
@@ -293,42 +593,121 @@ func (cfg *apiConfig) userLoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dbUserRow, err := cfg.dbQueries.GetUserByEmail(r.Context(), req.Email)
+	keys := loginKeys(r, req.Email)
+
+	retryAfter, err := cfg.reserveLoginAttempt(r.Context(), keys)
 	if err != nil {
-		// todo Some errors should 500
-		chirpySendErrorResponse(w, 401, "Incorrect email or password", err)
+		chirpySendErrorResponse(w, 500, "Authentication failed", err)
+		return
+	}
+
+	if retryAfter > 0 {
+		sendTooManyLoginAttempts(w, retryAfter)
 		return
 	}
 
-	matches, err := auth.CheckPasswordHash(req.Password, dbUserRow.HashedPassword)
+	dbUserRow, matches, err := cfg.checkCredentials(r.Context(), req.Email, req.Password)
 	if err != nil {
 		chirpySendErrorResponse(w, 500, "Authentication failed", err)
 		return
 	}
 
 	if !matches {
-		chirpySendErrorResponse(w, 401, "Incorrect email or password", err)
+		err = cfg.recordLoginFailure(r.Context(), keys)
+		if err != nil {
+			log.Printf("Error: %v", err)
+		}
+		chirpySendErrorResponse(w, 401, "Incorrect email or password", nil)
 		return
 	}
 
-	token, err := auth.MakeJWT(dbUserRow.ID, cfg.jwtSecret, cfg.jwtDuration)
+	err = cfg.releaseLoginAttempt(r.Context(), keys)
 	if err != nil {
-		chirpySendErrorResponse(w, 500, "Failed to generate auth token", err)
+		log.Printf("Error: %v", err)
+	}
+
+	// The password alone isn't enough once two-factor is enabled; the client
+	// trades the challenge token and a code for real tokens at /api/login/mfa
+	if dbUserRow.TotpEnabledAt.Valid {
+		cfg.sendMFAChallenge(w, dbUserRow)
+		return
 	}
 
-	refresh_token, err := auth.MakeRefreshToken()
+	cfg.completeLogin(w, r, dbUserRow)
+}
+
+// Looks up a user by email and checks their password, taking as long for an
+// unknown address as for a wrong password
+func (cfg *apiConfig) checkCredentials(ctx context.Context,
+	email, password string) (database.User, bool, error) {
+	dbUserRow, err := cfg.dbQueries.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		auth.CheckDummyPassword(password)
+		return database.User{}, false, nil
+	}
 	if err != nil {
-		chirpySendErrorResponse(w, 500, "Failed to generate refresh token", err)
+		return database.User{}, false, err
 	}
 
-	_, err = cfg.dbQueries.StoreRefreshToken(r.Context(),
-	database.StoreRefreshTokenParams{
-		refresh_token,
-		dbUserRow.ID,
-		dbUserRow.CreatedAt.Add(time.Hour * 24 * 60),
-	})
+	matches, needsRehash, err := auth.CheckPasswordHash(password, dbUserRow.HashedPassword)
 	if err != nil {
-		chirpySendErrorResponse(w, 500, "Failed to store refresh token", err)
+		return database.User{}, false, err
+	}
+
+	// Upgrades hashes made with old parameters while the password is known;
+	// the login goes ahead even if this fails
+	if matches && needsRehash {
+		err = cfg.rehashPassword(ctx, dbUserRow, password)
+		if err != nil {
+			log.Printf("Error: %v", err)
+		}
+	}
+
+	return dbUserRow, matches, nil
+}
+
+func (cfg *apiConfig) rehashPassword(ctx context.Context, dbUser database.User, password string) error {
+	passwordHash, err := auth.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("Failed to rehash password: %w", err)
+	}
+
+	err = cfg.dbQueries.RehashUserPassword(ctx,
+		database.RehashUserPasswordParams{
+			NewHash: passwordHash,
+			ID:      dbUser.ID,
+			OldHash: dbUser.HashedPassword,
+		})
+	if err != nil {
+		return fmt.Errorf("Failed to store rehashed password: %w", err)
+	}
+
+	return nil
+}
+
+// Issues tokens for a user who has fully authenticated
+func (cfg *apiConfig) completeLogin(w http.ResponseWriter, r *http.Request, dbUserRow database.User) {
+	err := cfg.clearLoginFailures(r.Context(), dbUserRow.Email)
+	if err != nil {
+		log.Printf("Error: %v", err)
+	}
+
+	err = cfg.cancelUserDeletion(r.Context(), dbUserRow.ID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to log in", err)
+		return
+	}
+
+	token, err := cfg.jwtKeys.MakeJWTWithRole(dbUserRow.ID, dbUserRow.Role, cfg.jwtDuration)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to generate auth token", err)
+		return
+	}
+
+	refresh_token, err := cfg.issueRefreshToken(r, dbUserRow.ID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to generate refresh token", err)
+		return
 	}
 
 	createdUser := chirpyUserInfo{
@@ -336,9 +715,15 @@ func (cfg *apiConfig) userLoginHandler(w http.ResponseWriter, r *http.Request) {
 		CreatedAt: dbUserRow.CreatedAt.String(),
 		UpdatedAt: dbUserRow.UpdatedAt.String(),
 		Email:     dbUserRow.Email,
+		EmailVerified: dbUserRow.EmailVerifiedAt.Valid,
 		Token:     token,
 		RefreshToken: refresh_token,
 		IsChirpyRed: dbUserRow.IsChirpyRed,
+		Role:        dbUserRow.Role,
+		Handle:      dbUserRow.Handle,
+		DisplayName: dbUserRow.DisplayName,
+		Bio:         dbUserRow.Bio,
+		AvatarURL:   dbUserRow.AvatarUrl,
 	}
 
 	res, err := chirpyEncodeJsonResponse(200, createdUser)
@@ -351,6 +736,34 @@ func (cfg *apiConfig) userLoginHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 
+const refreshTokenDuration = time.Hour * 24 * 60
+
+// Starts a new session, remembering the device it was started from
+func (cfg *apiConfig) issueRefreshToken(r *http.Request, userID uuid.UUID) (string, error) {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = cfg.dbQueries.StoreRefreshToken(r.Context(),
+		database.StoreRefreshTokenParams{
+			Token:     token,
+			UserID:    userID,
+			ExpiresAt: time.Now().Add(refreshTokenDuration),
+			FamilyID:  uuid.New(),
+			UserAgent: clientUserAgent(r),
+			Ip:        clientIP(r),
+		})
+	if err != nil {
+		return "", fmt.Errorf("Failed to store refresh token: %w", err)
+	}
+
+	return token, nil
+}
+
+// Exchanges a refresh token for a new JWT and a new refresh token
+// Presenting a token that was already exchanged means it has leaked, so
+// every token descended from the same login is revoked
 func (cfg *apiConfig) userAuthRefreshHandler(w http.ResponseWriter, r *http.Request) {
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -358,30 +771,71 @@ func (cfg *apiConfig) userAuthRefreshHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	dbTokenRow, err := cfg.dbQueries.GetRefreshToken(r.Context(), token)
+	newToken, err := auth.MakeRefreshToken()
 	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to generate refresh token", err)
+		return
+	}
+
+	dbTokenRow, err := cfg.dbQueries.RotateRefreshToken(r.Context(),
+		database.RotateRefreshTokenParams{
+			ReplacedBy: sql.NullString{String: newToken, Valid: true},
+			Token:      token,
+		})
+	if errors.Is(err, sql.ErrNoRows) {
+		dbTokenRow, err := cfg.dbQueries.GetRefreshToken(r.Context(), token)
+		if err == nil && dbTokenRow.ReplacedBy.Valid {
+			log.Printf("Refresh token reuse detected, revoking family %v of user %v",
+				dbTokenRow.FamilyID, dbTokenRow.UserID)
+
+			err = cfg.dbQueries.RevokeRefreshTokenFamily(r.Context(), dbTokenRow.FamilyID)
+			if err != nil {
+				chirpySendErrorResponse(w, 500, "Token refresh failed", err)
+				return
+			}
+		}
 		chirpySendErrorResponse(w, 401, "Token refresh failed", err)
 		return
 	}
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Token refresh failed", err)
+		return
+	}
 
-	now := time.Now()
+	_, err = cfg.dbQueries.StoreRefreshToken(r.Context(),
+		database.StoreRefreshTokenParams{
+			Token:     newToken,
+			UserID:    dbTokenRow.UserID,
+			ExpiresAt: time.Now().Add(refreshTokenDuration),
+			FamilyID:  dbTokenRow.FamilyID,
+			UserAgent: dbTokenRow.UserAgent,
+			Ip:        dbTokenRow.Ip,
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to store refresh token", err)
+		return
+	}
 
-	if dbTokenRow.RevokedAt.Valid || now.After(dbTokenRow.ExpiresAt) {
+	// The role may have changed since the session started
+	dbUserRow, err := cfg.dbQueries.GetUserByID(r.Context(), dbTokenRow.UserID)
+	if err != nil {
 		chirpySendErrorResponse(w, 401, "Token refresh failed", err)
 		return
 	}
 
-	jwt, err := auth.MakeJWT(dbTokenRow.UserID, cfg.jwtSecret, cfg.jwtDuration)
+	jwt, err := cfg.jwtKeys.MakeJWTWithRole(dbUserRow.ID, dbUserRow.Role, cfg.jwtDuration)
 	if err != nil {
 		chirpySendErrorResponse(w, 401, "Token refresh failed", err)
 		return
 	}
 
 	type chirpyJWT struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 	response := chirpyJWT{
-		Token: jwt,
+		Token:        jwt,
+		RefreshToken: newToken,
 	}
 
 	res, err := chirpyEncodeJsonResponse(200, response)
@@ -411,6 +865,13 @@ func (cfg *apiConfig) userAuthRevokeHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Tokens rotated from the same login go too
+	err = cfg.dbQueries.RevokeRefreshTokenFamily(r.Context(), dbTokenRow.FamilyID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to revoke token", err)
+		return
+	}
+
 	w.WriteHeader(204)
 	w.Write([]byte{})
 }
@@ -421,69 +882,284 @@ type chirp struct {
 	UpdatedAt string `json:"updated_at"`
 	Body      string `json:"body"`
 	UserID    string `json:"user_id"`
+	InReplyTo string `json:"in_reply_to,omitempty"`
+	RechirpOf string `json:"rechirp_of,omitempty"`
+	Rechirped *chirp `json:"rechirped,omitempty"`
+	Deleted   bool   `json:"deleted,omitempty"`
+	Hidden    bool   `json:"hidden,omitempty"`
+	LikeCount int64  `json:"like_count"`
+	LikedByMe bool   `json:"liked_by_me"`
+}
+
+func chirpFromDatabase(c database.Chirp) chirp {
+	if c.DeletedAt.Valid {
+		return chirpTombstone(c.ID, c.InReplyTo)
+	}
+
+	response := chirp{
+		ID:        c.ID.String(),
+		CreatedAt: c.CreatedAt.String(),
+		UpdatedAt: c.UpdatedAt.String(),
+		Body:      c.Body,
+		UserID:    c.UserID.String(),
+	}
+	if c.InReplyTo.Valid {
+		response.InReplyTo = c.InReplyTo.UUID.String()
+	}
+	if c.RechirpOf.Valid {
+		response.RechirpOf = c.RechirpOf.UUID.String()
+	}
+	response.Hidden = c.HiddenAt.Valid
+	return response
+}
+
+// Chirps hidden by a moderator are only visible to their author
+func chirpHiddenFrom(c database.Chirp, viewer uuid.NullUUID) bool {
+	return c.HiddenAt.Valid && (!viewer.Valid || viewer.UUID != c.UserID)
+}
+
+// Stands in for a deleted chirp that other chirps still refer to
+// inReplyTo is unknown if the chirp no longer exists at all
+func chirpTombstone(id uuid.UUID, inReplyTo uuid.NullUUID) chirp {
+	response := chirp{
+		ID:      id.String(),
+		Deleted: true,
+	}
+	if inReplyTo.Valid {
+		response.InReplyTo = inReplyTo.UUID.String()
+	}
+	return response
+}
+
+// Converts chirps for a response, embedding rechirped chirps and filling in
+// like counts with one query each rather than one per chirp
+// viewer is the authenticated user, if any, for liked_by_me
+func (cfg *apiConfig) chirpResponses(ctx context.Context,
+	dbChirps []database.Chirp, viewer uuid.NullUUID) ([]chirp, error) {
+	response := []chirp{}
+
+	if len(dbChirps) == 0 {
+		return response, nil
+	}
+
+	// Hidden chirps show up as tombstones for everyone but their author
+	gone := func(c database.Chirp) bool {
+		return c.DeletedAt.Valid || chirpHiddenFrom(c, viewer)
+	}
+
+	ids := []uuid.UUID{}
+	rechirpedIDs := []uuid.UUID{}
+
+	for _, c := range dbChirps {
+		ids = append(ids, c.ID)
+		if c.RechirpOf.Valid && !gone(c) {
+			rechirpedIDs = append(rechirpedIDs, c.RechirpOf.UUID)
+		}
+	}
+
+	rechirped := map[uuid.UUID]database.Chirp{}
+
+	if len(rechirpedIDs) > 0 {
+		originals, err := cfg.dbQueries.GetChirpsByIDs(ctx, rechirpedIDs)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get rechirped chirps: %w", err)
+		}
+		for _, c := range originals {
+			rechirped[c.ID] = c
+			ids = append(ids, c.ID)
+		}
+	}
+
+	summaries, err := cfg.dbQueries.GetLikeSummaries(ctx,
+		database.GetLikeSummariesParams{
+			ViewerID: viewer,
+			ChirpIds: ids,
+		})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get like counts: %w", err)
+	}
+
+	likes := map[uuid.UUID]database.GetLikeSummariesRow{}
+	for _, summary := range summaries {
+		likes[summary.ChirpID] = summary
+	}
+
+	withLikes := func(c database.Chirp) chirp {
+		if gone(c) {
+			return chirpTombstone(c.ID, c.InReplyTo)
+		}
+		response := chirpFromDatabase(c)
+		response.LikeCount = likes[c.ID].LikeCount
+		response.LikedByMe = likes[c.ID].LikedByMe
+		return response
+	}
+
+	for _, c := range dbChirps {
+		r := withLikes(c)
+
+		if c.RechirpOf.Valid && !gone(c) {
+			original, ok := rechirped[c.RechirpOf.UUID]
+			embedded := chirpTombstone(c.RechirpOf.UUID, uuid.NullUUID{})
+			if ok {
+				embedded = withLikes(original)
+			}
+			r.Rechirped = &embedded
+		}
+
+		response = append(response, r)
+	}
+
+	return response, nil
+}
+
+// Public endpoints personalise responses when a valid token is sent
+// An invalid token is still an error so clients notice expired sessions
+func (cfg *apiConfig) optionalViewer(r *http.Request) (uuid.NullUUID, error) {
+	if r.Header.Get("Authorization") == "" {
+		return uuid.NullUUID{}, nil
+	}
+
+	userID, err := cfg.authenticate(r, auth.ScopeChirpsRead)
+	if err != nil {
+		return uuid.NullUUID{}, err
+	}
+
+	return uuid.NullUUID{UUID: userID, Valid: true}, nil
+}
+
+const maxChirpLength = 140
+
+// Checks the length of a new chirp body and runs it through moderation
+func (cfg *apiConfig) moderateChirpBody(body string) (moderation.Result, error) {
+	if len(body) > maxChirpLength {
+		return moderation.Result{}, fmt.Errorf("Chirp is %v bytes long", len(body))
+	}
+
+	return cfg.contentFilter.Check(body), nil
 }
 
 func (cfg *apiConfig) chirpCreateHandler(w http.ResponseWriter, r *http.Request) {
 	c := chirp{}
 
-	token, err := auth.GetBearerToken(r.Header)
+	userID, err := cfg.authenticate(r, auth.ScopeChirpsWrite)
 	if err != nil {
-		chirpySendErrorResponse(w, 401, "Authorization failed", err)
+		sendAuthenticationError(w, err)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	err = chirpyDecodeJsonRequest(r, &c)
 	if err != nil {
-		chirpySendErrorResponse(w, 401, "Authorization failed", err)
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
 		return
 	}
 
-	err = chirpyDecodeJsonRequest(r, &c)
+	moderated, err := cfg.moderateChirpBody(c.Body)
 	if err != nil {
-		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		chirpySendErrorResponse(w, 400, "Chirp is too long", err)
 		return
 	}
 
-	const maxChirpLength = 140
-	words := strings.Split(c.Body, " ")
-	newWords := []string{}
-	badWords := []string{
-		"kerfuffle",
-		"sharbert",
-		"fornax",
+	if moderated.Rejected {
+		chirpySendErrorResponse(w, 400, "Chirp violates content rules",
+			fmt.Errorf("Matched: %v", moderated.Matches))
+		return
 	}
 
-	for _, word := range words {
-		for _, badWord := range badWords {
-			if strings.ToLower(word) == badWord {
-				word = "****"
-				break
-			}
+	cleanedBody := moderated.Body
+
+	inReplyTo := uuid.NullUUID{}
+	rechirpOf := uuid.NullUUID{}
+
+	if len(c.InReplyTo) > 0 {
+		parentID, err := uuid.Parse(c.InReplyTo)
+		if err != nil {
+			chirpySendErrorResponse(w, 400, "Invalid in_reply_to", err)
+			return
 		}
-		newWords = append(newWords, word)
-	}
 
-	if len(c.Body) > maxChirpLength {
-		chirpySendErrorResponse(w, 400, "Chirp is too long", nil)
-		return
+		parent, err := cfg.dbQueries.GetChirpByID(r.Context(), parentID)
+		if err != nil || parent.DeletedAt.Valid ||
+			chirpHiddenFrom(parent, uuid.NullUUID{UUID: userID, Valid: true}) {
+			chirpySendErrorResponse(w, 400, "Parent chirp not found", err)
+			return
+		}
+
+		inReplyTo = uuid.NullUUID{UUID: parent.ID, Valid: true}
 	}
 
-	cleanedBody := strings.Join(newWords, " ")
+	// An empty body makes a plain rechirp, anything else quotes the original
+	if len(c.RechirpOf) > 0 {
+		originalID, err := uuid.Parse(c.RechirpOf)
+		if err != nil {
+			chirpySendErrorResponse(w, 400, "Invalid rechirp_of", err)
+			return
+		}
+
+		original, err := cfg.dbQueries.GetChirpByID(r.Context(), originalID)
+		if err != nil || original.DeletedAt.Valid ||
+			chirpHiddenFrom(original, uuid.NullUUID{UUID: userID, Valid: true}) {
+			chirpySendErrorResponse(w, 400, "Rechirped chirp not found", err)
+			return
+		}
+
+		plain := len(cleanedBody) == 0
+
+		// Rechirping a plain rechirp reposts what it reposted
+		if len(original.Body) == 0 && original.RechirpOf.Valid && plain {
+			original, err = cfg.dbQueries.GetChirpByID(r.Context(), original.RechirpOf.UUID)
+			if err != nil || original.DeletedAt.Valid ||
+				chirpHiddenFrom(original, uuid.NullUUID{UUID: userID, Valid: true}) {
+				chirpySendErrorResponse(w, 400, "Rechirped chirp not found", err)
+				return
+			}
+		}
+
+		if plain && original.UserID == userID {
+			chirpySendErrorResponse(w, 400, "Cannot rechirp your own chirp", nil)
+			return
+		}
+
+		if plain && inReplyTo.Valid {
+			chirpySendErrorResponse(w, 400, "Rechirps cannot be replies", nil)
+			return
+		}
+
+		rechirpOf = uuid.NullUUID{UUID: original.ID, Valid: true}
+	}
 
 	dbStatus, err := cfg.dbQueries.CreateChirp(r.Context(),
 		database.CreateChirpParams{
-			cleanedBody,
-			userID,
+			Body:      cleanedBody,
+			UserID:    userID,
+			InReplyTo: inReplyTo,
+			RechirpOf: rechirpOf,
 		})
+	if err != nil {
+		e, ok := err.(*pq.Error)
+		if ok &&
+			e.Code.Name() == "unique_violation" &&
+			e.Constraint == "chirps_plain_rechirp_key" {
 
-	response := chirp{
-		ID:        dbStatus.ID.String(),
-		CreatedAt: dbStatus.CreatedAt.String(),
-		UpdatedAt: dbStatus.UpdatedAt.String(),
-		Body:      dbStatus.Body,
-		UserID:    dbStatus.UserID.String(),
+			chirpySendErrorResponse(w, 400, "Already rechirped", e)
+			return
+		}
+		chirpySendErrorResponse(w, 500, "Failed to create chirp", err)
+		return
+	}
+
+	if moderated.Flagged {
+		cfg.flagChirp(r.Context(), dbStatus.ID, moderated)
+	}
+
+	chirps, err := cfg.chirpResponses(r.Context(),
+		[]database.Chirp{dbStatus}, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to create chirp", err)
+		return
 	}
 
+	response := chirps[0]
+
 	res, err := chirpyEncodeJsonResponse(201, response)
 	if err != nil {
 		log.Printf("Error: %v", err)
@@ -493,6 +1169,12 @@ func (cfg *apiConfig) chirpCreateHandler(w http.ResponseWriter, r *http.Request)
 	chirpySendResponse(w, res)
 }
 
+type chirpPage struct {
+	Chirps     []chirp `json:"chirps"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+	PrevCursor string  `json:"prev_cursor,omitempty"`
+}
+
 func (cfg *apiConfig) chirpsGetHandler(w http.ResponseWriter, r *http.Request) {
 	authorID := r.URL.Query().Get("author_id")
 
@@ -505,7 +1187,19 @@ func (cfg *apiConfig) chirpsGetHandler(w http.ResponseWriter, r *http.Request) {
 
 	sortAsc := sortBy == "asc"
 
-	dbChirps := []database.Chirp{}
+	viewer, err := cfg.optionalViewer(r)
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	page, err := parsePageRequest(r)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid pagination parameters", err)
+		return
+	}
+
+	author := uuid.NullUUID{}
 
 	if len(authorID) > 0 {
 		authorID, err := uuid.Parse(authorID)
@@ -513,35 +1207,50 @@ func (cfg *apiConfig) chirpsGetHandler(w http.ResponseWriter, r *http.Request) {
 			chirpySendErrorResponse(w, 400, "Invalid author id", err)
 			return
 		}
-		dbChirps, err = cfg.dbQueries.GetChirpsByAuthorID(r.Context(), authorID)
-		if err != nil {
-			chirpySendErrorResponse(w, 500, "Failed to get chirps", err)
-			return
-		}
-	} else {
-		var err error = nil
-		dbChirps, err = cfg.dbQueries.GetAllChirps(r.Context())
-		if err != nil {
-			chirpySendErrorResponse(w, 500, "Failed to get chirps", err)
-			return
-		}
+		author = uuid.NullUUID{UUID: authorID, Valid: true}
 	}
 
-	sort.Slice(dbChirps, func(i, j int) bool {
-		return sortAsc == dbChirps[i].CreatedAt.Before(dbChirps[j].CreatedAt)
-	})
-
+	dbChirps := []database.Chirp{}
 
-	response := []chirp{}
+	if page.scanAscending(sortAsc) {
+		dbChirps, err = cfg.dbQueries.ListChirpsAfter(r.Context(),
+			database.ListChirpsAfterParams{
+				AuthorID:        author,
+				ViewerID:        viewer,
+				CursorCreatedAt: page.cursorCreatedAt(),
+				CursorID:        page.cursorID(),
+				Limit:           page.fetchLimit(),
+			})
+	} else {
+		dbChirps, err = cfg.dbQueries.ListChirpsBefore(r.Context(),
+			database.ListChirpsBeforeParams{
+				AuthorID:        author,
+				ViewerID:        viewer,
+				CursorCreatedAt: page.cursorCreatedAt(),
+				CursorID:        page.cursorID(),
+				Limit:           page.fetchLimit(),
+			})
+	}
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get chirps", err)
+		return
+	}
 
-	for _, c := range dbChirps {
-		response = append(response, chirp{
-			ID:        c.ID.String(),
-			CreatedAt: c.CreatedAt.String(),
-			UpdatedAt: c.UpdatedAt.String(),
-			Body:      c.Body,
-			UserID:    c.UserID.String(),
+	dbChirps, next, prev := paginate(dbChirps, page,
+		func(c database.Chirp) pageCursor {
+			return pageCursor{CreatedAt: c.CreatedAt, ID: c.ID}
 		})
+
+	chirps, err := cfg.chirpResponses(r.Context(), dbChirps, viewer)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get chirps", err)
+		return
+	}
+
+	response := chirpPage{
+		Chirps:     chirps,
+		NextCursor: encodePageCursor(next),
+		PrevCursor: encodePageCursor(prev),
 	}
 
 	res, err := chirpyEncodeJsonResponse(200, response)
@@ -550,19 +1259,14 @@ func (cfg *apiConfig) chirpsGetHandler(w http.ResponseWriter, r *http.Request) {
 		// continue
 	}
 
+	setPageLinks(w, r, next, prev)
 	chirpySendResponse(w, res)
 }
 
 func (cfg *apiConfig) chirpDeleteHandler(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+	userID, err := cfg.authenticate(r, auth.ScopeChirpsWrite)
 	if err != nil {
-		chirpySendErrorResponse(w, 401, "Authorization failed", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		chirpySendErrorResponse(w, 401, "Authorization failed", err)
+		sendAuthenticationError(w, err)
 		return
 	}
 
@@ -576,7 +1280,7 @@ func (cfg *apiConfig) chirpDeleteHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	dbChirpRow, err := cfg.dbQueries.GetChirpByID(r.Context(), chirpID)
-	if err != nil {
+	if err != nil || dbChirpRow.DeletedAt.Valid {
 		chirpySendErrorResponse(w, 404, "Chirp not found", err)
 		return
 	}
@@ -586,11 +1290,26 @@ func (cfg *apiConfig) chirpDeleteHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	_, err = cfg.dbQueries.DeleteChirpByID(r.Context(),
-	database.DeleteChirpByIDParams{
-		chirpID,
-		userID,
-	})
+	// Replies keep their place in the thread under a tombstone
+	hasReplies, err := cfg.dbQueries.HasReplies(r.Context(), chirpID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to delete chirp", err)
+		return
+	}
+
+	if hasReplies {
+		_, err = cfg.dbQueries.MarkChirpDeleted(r.Context(),
+			database.MarkChirpDeletedParams{
+				ID:     chirpID,
+				UserID: userID,
+			})
+	} else {
+		_, err = cfg.dbQueries.DeleteChirpByID(r.Context(),
+			database.DeleteChirpByIDParams{
+				ID:     chirpID,
+				UserID: userID,
+			})
+	}
 
 	if err != nil {
 		chirpySendErrorResponse(w, 500, "Failed to delete chirp", err)
@@ -611,20 +1330,27 @@ func (cfg *apiConfig) chirpGetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dbStatus, err := cfg.dbQueries.GetChirpByID(r.Context(), chirpID)
+	viewer, err := cfg.optionalViewer(r)
 	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	dbStatus, err := cfg.dbQueries.GetChirpByID(r.Context(), chirpID)
+	if err != nil || dbStatus.DeletedAt.Valid || chirpHiddenFrom(dbStatus, viewer) {
 		chirpySendErrorResponse(w, 404, "Chirp not found", err)
 		return
 	}
 
-	response := chirp{
-		ID:        dbStatus.ID.String(),
-		CreatedAt: dbStatus.CreatedAt.String(),
-		UpdatedAt: dbStatus.UpdatedAt.String(),
-		Body:      dbStatus.Body,
-		UserID:    dbStatus.UserID.String(),
+	chirps, err := cfg.chirpResponses(r.Context(),
+		[]database.Chirp{dbStatus}, viewer)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get chirp", err)
+		return
 	}
 
+	response := chirps[0]
+
 	res, err := chirpyEncodeJsonResponse(200, response)
 	if err != nil {
 		log.Printf("Error: %v", err)
@@ -683,6 +1409,15 @@ func (cfg *apiConfig) upgradeUserToChirpyRedHandler(w http.ResponseWriter, r *ht
 			return
 		}
 
+		err = cfg.dbQueries.RecordSubscriptionEvent(r.Context(),
+			database.RecordSubscriptionEventParams{
+				UserID: userID,
+				Event:  req.Event,
+			})
+		if err != nil {
+			log.Printf("Error: Failed to record subscription event: %v", err)
+		}
+
 		w.WriteHeader(204)
 		w.Write([]byte{})
 		return
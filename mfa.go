@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/auth"
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+)
+
+const (
+	totpIssuer        = "Chirpy"
+	mfaTokenDuration  = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+// Checks a TOTP code, or a recovery code if one is given, and uses it up so
+// it can't be presented again
+func (cfg *apiConfig) checkSecondFactor(ctx context.Context, dbUser database.User,
+	code, recoveryCode string) (bool, error) {
+	if len(recoveryCode) > 0 {
+		rows, err := cfg.dbQueries.UseRecoveryCode(ctx,
+			database.UseRecoveryCodeParams{
+				UserID:   dbUser.ID,
+				CodeHash: auth.HashRecoveryCode(recoveryCode),
+			})
+		if err != nil {
+			return false, fmt.Errorf("Failed to use recovery code: %w", err)
+		}
+		return rows > 0, nil
+	}
+
+	step, err := auth.ValidateTOTP(dbUser.TotpSecret.String, code, time.Now(), dbUser.TotpLastStep)
+	if err != nil {
+		return false, nil
+	}
+
+	// Another request may have used the same code since the user was read
+	rows, err := cfg.dbQueries.UseTOTPStep(ctx,
+		database.UseTOTPStepParams{
+			ID:           dbUser.ID,
+			TotpLastStep: step,
+		})
+	if err != nil {
+		return false, fmt.Errorf("Failed to use code: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// Has a logged-in user prove who they are again before something a stolen
+// access token alone mustn't be able to do, sending the error response if
+// they can't. Takes the password, and a code too once two-factor is enabled;
+// wrong guesses count towards the login lockout
+func (cfg *apiConfig) reauthenticate(w http.ResponseWriter, r *http.Request, dbUser database.User,
+	password, code, recoveryCode string) bool {
+	keys := loginKeys(r, dbUser.Email)
+
+	retryAfter, err := cfg.reserveLoginAttempt(r.Context(), keys)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Authentication failed", err)
+		return false
+	}
+
+	if retryAfter > 0 {
+		sendTooManyLoginAttempts(w, retryAfter)
+		return false
+	}
+
+	matches, _, err := auth.CheckPasswordHash(password, dbUser.HashedPassword)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Authentication failed", err)
+		return false
+	}
+
+	if matches && dbUser.TotpEnabledAt.Valid {
+		matches, err = cfg.checkSecondFactor(r.Context(), dbUser, code, recoveryCode)
+		if err != nil {
+			chirpySendErrorResponse(w, 500, "Authentication failed", err)
+			return false
+		}
+	}
+
+	if !matches {
+		err = cfg.recordLoginFailure(r.Context(), keys)
+		if err != nil {
+			log.Printf("Error: %v", err)
+		}
+		chirpySendErrorResponse(w, 403, "Incorrect password or code", nil)
+		return false
+	}
+
+	err = cfg.releaseLoginAttempt(r.Context(), keys)
+	if err != nil {
+		log.Printf("Error: %v", err)
+	}
+
+	return true
+}
+
+// Replaces any existing recovery codes with a fresh set
+func (cfg *apiConfig) createRecoveryCodes(ctx context.Context, dbUser database.User) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.dbQueries.DeleteRecoveryCodes(ctx, dbUser.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to delete recovery codes: %w", err)
+	}
+
+	for _, code := range codes {
+		err = cfg.dbQueries.CreateRecoveryCode(ctx,
+			database.CreateRecoveryCodeParams{
+				UserID:   dbUser.ID,
+				CodeHash: auth.HashRecoveryCode(code),
+			})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to store recovery code: %w", err)
+		}
+	}
+
+	return codes, nil
+}
+
+// Answers a correct password for a user with two-factor enabled
+func (cfg *apiConfig) sendMFAChallenge(w http.ResponseWriter, dbUser database.User) {
+	mfaToken, err := cfg.jwtKeys.MakeMFAToken(dbUser.ID, mfaTokenDuration)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to generate MFA token", err)
+		return
+	}
+
+	response := struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}{
+		MFARequired: true,
+		MFAToken:    mfaToken,
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+// Second step of logging in: exchanges the challenge token from /api/login
+// and a code for an access token and a refresh token
+func (cfg *apiConfig) loginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}{}
+
+	err := chirpyDecodeJsonRequest(r, &req)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		return
+	}
+
+	challenge, err := cfg.jwtKeys.ParseMFAToken(req.MFAToken)
+	if err != nil {
+		chirpySendErrorResponse(w, 401, "Invalid MFA token", err)
+		return
+	}
+
+	revoked, err := accessTokenDenylist{dbQueries: cfg.dbQueries}.IsRevoked(r.Context(), challenge)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Authentication failed", err)
+		return
+	}
+	if revoked {
+		chirpySendErrorResponse(w, 401, "Invalid MFA token", nil)
+		return
+	}
+
+	dbUserRow, err := cfg.dbQueries.GetUserByID(r.Context(), challenge.UserID)
+	if err != nil {
+		chirpySendErrorResponse(w, 401, "Invalid MFA token", err)
+		return
+	}
+
+	if !dbUserRow.TotpEnabledAt.Valid {
+		chirpySendErrorResponse(w, 401, "Invalid MFA token", nil)
+		return
+	}
+
+	// Codes are guessed far more easily than passwords, so they count
+	// towards the same lockout
+	keys := loginKeys(r, dbUserRow.Email)
+
+	retryAfter, err := cfg.reserveLoginAttempt(r.Context(), keys)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Authentication failed", err)
+		return
+	}
+
+	if retryAfter > 0 {
+		sendTooManyLoginAttempts(w, retryAfter)
+		return
+	}
+
+	matches, err := cfg.checkSecondFactor(r.Context(), dbUserRow, req.Code, req.RecoveryCode)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Authentication failed", err)
+		return
+	}
+
+	if !matches {
+		err = cfg.recordLoginFailure(r.Context(), keys)
+		if err != nil {
+			log.Printf("Error: %v", err)
+		}
+		chirpySendErrorResponse(w, 401, "Incorrect code", nil)
+		return
+	}
+
+	err = cfg.releaseLoginAttempt(r.Context(), keys)
+	if err != nil {
+		log.Printf("Error: %v", err)
+	}
+
+	// Each challenge can only complete one login
+	err = cfg.dbQueries.RevokeAccessToken(r.Context(),
+		database.RevokeAccessTokenParams{
+			Jti:       challenge.ID,
+			UserID:    challenge.UserID,
+			ExpiresAt: challenge.ExpiresAt,
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Authentication failed", err)
+		return
+	}
+
+	cfg.completeLogin(w, r, dbUserRow)
+}
+
+// Starts enrolling the caller in two-factor authentication
+// Nothing changes at login until the secret is confirmed with a code
+func (cfg *apiConfig) totpEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	dbUserRow, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 401, "Authorization failed", err)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to generate secret", err)
+		return
+	}
+
+	rows, err := cfg.dbQueries.SetTOTPSecret(r.Context(),
+		database.SetTOTPSecretParams{
+			ID:         userID,
+			TotpSecret: sql.NullString{String: secret, Valid: true},
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to store secret", err)
+		return
+	}
+
+	if rows == 0 {
+		chirpySendErrorResponse(w, 400, "Two-factor authentication already enabled", nil)
+		return
+	}
+
+	response := struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(totpIssuer, dbUserRow.Email, secret),
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+// Turns on two-factor authentication once the user shows their authenticator
+// produces the right codes, and hands out recovery codes
+// The recovery codes are only ever shown here
+func (cfg *apiConfig) totpConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	req := struct {
+		Code string `json:"code"`
+	}{}
+
+	err = chirpyDecodeJsonRequest(r, &req)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		return
+	}
+
+	dbUserRow, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 401, "Authorization failed", err)
+		return
+	}
+
+	if dbUserRow.TotpEnabledAt.Valid {
+		chirpySendErrorResponse(w, 400, "Two-factor authentication already enabled", nil)
+		return
+	}
+
+	if !dbUserRow.TotpSecret.Valid {
+		chirpySendErrorResponse(w, 400, "Two-factor enrollment not started", nil)
+		return
+	}
+
+	step, err := auth.ValidateTOTP(dbUserRow.TotpSecret.String, req.Code,
+		time.Now(), dbUserRow.TotpLastStep)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Incorrect code", err)
+		return
+	}
+
+	rows, err := cfg.dbQueries.EnableTOTP(r.Context(),
+		database.EnableTOTPParams{
+			ID:           userID,
+			TotpLastStep: step,
+			TotpSecret:   dbUserRow.TotpSecret,
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to enable two-factor authentication", err)
+		return
+	}
+
+	if rows == 0 {
+		chirpySendErrorResponse(w, 409, "Two-factor enrollment changed, try again", nil)
+		return
+	}
+
+	codes, err := cfg.createRecoveryCodes(r.Context(), dbUserRow)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to generate recovery codes", err)
+		return
+	}
+
+	response := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: codes,
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+// Turns off two-factor authentication; takes a current code or a recovery
+// code so a stolen access token alone can't do it
+func (cfg *apiConfig) totpDisableHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	req := struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}{}
+
+	err = chirpyDecodeJsonRequest(r, &req)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		return
+	}
+
+	dbUserRow, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 401, "Authorization failed", err)
+		return
+	}
+
+	if !dbUserRow.TotpEnabledAt.Valid {
+		chirpySendErrorResponse(w, 400, "Two-factor authentication not enabled", nil)
+		return
+	}
+
+	matches, err := cfg.checkSecondFactor(r.Context(), dbUserRow, req.Code, req.RecoveryCode)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to disable two-factor authentication", err)
+		return
+	}
+
+	if !matches {
+		chirpySendErrorResponse(w, 403, "Incorrect code", nil)
+		return
+	}
+
+	err = cfg.dbQueries.DisableTOTP(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to disable two-factor authentication", err)
+		return
+	}
+
+	err = cfg.dbQueries.DeleteRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to delete recovery codes", err)
+		return
+	}
+
+	w.WriteHeader(204)
+	w.Write([]byte{})
+}
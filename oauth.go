@@ -0,0 +1,768 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/auth"
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+)
+
+// RFC 6749 recommends at most ten minutes
+const authorizationCodeDuration = 5 * time.Minute
+
+// What the consent page tells the user each scope allows
+var scopeDescriptions = map[string]string{
+	auth.ScopeChirpsRead:   "Read chirps, including your timeline",
+	auth.ScopeChirpsWrite:  "Post, edit, delete, like and report chirps as you",
+	auth.ScopeProfileWrite: "Change your profile and who you follow",
+}
+
+// An error response as described in RFC 6749 section 5.2
+func sendOAuthError(w http.ResponseWriter, status int, code, description string, err error) {
+	if err != nil {
+		log.Printf("Error: %v: %v", description, err)
+	}
+
+	response := struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}{
+		Error:            code,
+		ErrorDescription: description,
+	}
+
+	res, err := chirpyEncodeJsonResponse(status, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	chirpySendResponse(w, res)
+}
+
+// A validated authorization request, from the query of GET /oauth/authorize
+// or the consent form
+type authorizeRequest struct {
+	Client        database.OauthClient
+	RedirectURI   string
+	State         string
+	Scopes        []string
+	CodeChallenge string
+}
+
+// Finds the client and checks the redirect URI is one it registered
+// Until both are known to be good errors can't be sent to the redirect URI,
+// since that would make Chirpy an open redirector
+func (cfg *apiConfig) authorizeRequestClient(ctx context.Context,
+	values url.Values) (database.OauthClient, string, error) {
+	clientID, err := uuid.Parse(values.Get("client_id"))
+	if err != nil {
+		return database.OauthClient{}, "", fmt.Errorf("Invalid client_id")
+	}
+
+	dbClient, err := cfg.dbQueries.GetOAuthClient(ctx, clientID)
+	if err != nil {
+		return database.OauthClient{}, "", fmt.Errorf("Unknown client")
+	}
+
+	// The redirect URI may be left out if the client only registered one
+	redirectURI := values.Get("redirect_uri")
+	if redirectURI == "" && len(dbClient.RedirectUris) == 1 {
+		redirectURI = dbClient.RedirectUris[0]
+	}
+
+	if !slices.Contains(dbClient.RedirectUris, redirectURI) {
+		return database.OauthClient{}, "", fmt.Errorf("Redirect URI not registered for this client")
+	}
+
+	return dbClient, redirectURI, nil
+}
+
+// Checks the rest of an authorization request; errors carry the RFC 6749
+// error code to send back to the client
+func parseAuthorizeRequest(dbClient database.OauthClient, redirectURI string,
+	values url.Values) (authorizeRequest, string, error) {
+	req := authorizeRequest{
+		Client:      dbClient,
+		RedirectURI: redirectURI,
+		State:       values.Get("state"),
+	}
+
+	if values.Get("response_type") != "code" {
+		return req, "unsupported_response_type", fmt.Errorf("Only the code response type is supported")
+	}
+
+	// Defaults to everything the client registered for
+	requested := strings.Fields(values.Get("scope"))
+	if len(requested) == 0 {
+		requested = dbClient.Scopes
+	}
+
+	scopes, err := auth.ParseScopes(requested)
+	if err != nil {
+		return req, "invalid_scope", err
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(dbClient.Scopes, scope) {
+			return req, "invalid_scope", fmt.Errorf("Client isn't registered for scope %v", scope)
+		}
+	}
+	req.Scopes = scopes
+
+	// PKCE is required of every client, confidential ones included
+	err = auth.ValidatePKCEChallenge(values.Get("code_challenge"), values.Get("code_challenge_method"))
+	if err != nil {
+		return req, "invalid_request", err
+	}
+	req.CodeChallenge = values.Get("code_challenge")
+
+	return req, "", nil
+}
+
+// Sends the browser back to the client with params added to the redirect
+// URI's query
+func (cfg *apiConfig) oauthRedirect(w http.ResponseWriter, r *http.Request,
+	redirectURI string, params url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Invalid redirect URI", err)
+		return
+	}
+
+	// RFC 9207 lets clients check which server the response came from
+	params.Set("iss", cfg.jwtKeys.Issuer)
+
+	query := u.Query()
+	for key := range params {
+		query.Set(key, params.Get(key))
+	}
+	u.RawQuery = query.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+func (cfg *apiConfig) oauthRedirectError(w http.ResponseWriter, r *http.Request,
+	req authorizeRequest, code string, err error) {
+	params := url.Values{}
+	params.Set("error", code)
+	if err != nil {
+		params.Set("error_description", err.Error())
+	}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	cfg.oauthRedirect(w, r, req.RedirectURI, params)
+}
+
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Authorize {{.Request.Client.Name}} - Chirpy</title>
+</head>
+<body>
+{{if .Problem}}
+<h1>Can't authorize this app</h1>
+<p>{{.Problem}}</p>
+{{else}}
+<h1>Authorize {{.Request.Client.Name}}</h1>
+<p>{{.Request.Client.Name}} wants to use your Chirpy account to:</p>
+<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>
+<p>Sign in to allow this. {{.Request.Client.Name}} won't see your password.</p>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+<form method="post" action="/oauth/authorize">
+<input type="hidden" name="response_type" value="code">
+<input type="hidden" name="client_id" value="{{.Request.Client.ID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="S256">
+<p><label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required></label></p>
+<p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+<p><label>Two-factor code, if enabled <input type="text" name="totp_code" inputmode="numeric" autocomplete="one-time-code"></label></p>
+<p>
+<button type="submit" name="decision" value="approve">Allow</button>
+<button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+</p>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+type consentPageData struct {
+	Request authorizeRequest
+	Scopes  []string
+	Scope   string
+	Email   string
+	Error   string
+	// Set instead of showing the form when the request can't be trusted
+	Problem string
+}
+
+func sendConsentPage(w http.ResponseWriter, status int, data consentPageData) {
+	for _, scope := range data.Request.Scopes {
+		data.Scopes = append(data.Scopes, scopeDescriptions[scope])
+	}
+	data.Scope = strings.Join(data.Request.Scopes, " ")
+
+	// The form takes a password, so it must never be framed by another site
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+	w.WriteHeader(status)
+
+	err := consentPage.Execute(w, data)
+	if err != nil {
+		log.Printf("Error: Failed to render consent page: %v", err)
+	}
+}
+
+// Start of the authorization code flow: shows the user what the client is
+// asking for and lets them sign in to allow it
+func (cfg *apiConfig) oauthAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	dbClient, redirectURI, err := cfg.authorizeRequestClient(r.Context(), r.URL.Query())
+	if err != nil {
+		sendConsentPage(w, 400, consentPageData{Problem: err.Error()})
+		return
+	}
+
+	req, code, err := parseAuthorizeRequest(dbClient, redirectURI, r.URL.Query())
+	if err != nil {
+		cfg.oauthRedirectError(w, r, req, code, err)
+		return
+	}
+
+	sendConsentPage(w, 200, consentPageData{Request: req})
+}
+
+// The consent form: signs the user in and sends an authorization code to
+// the client, or tells it the user said no
+func (cfg *apiConfig) oauthAuthorizeSubmitHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		sendConsentPage(w, 400, consentPageData{Problem: "Invalid form"})
+		return
+	}
+
+	dbClient, redirectURI, err := cfg.authorizeRequestClient(r.Context(), r.PostForm)
+	if err != nil {
+		sendConsentPage(w, 400, consentPageData{Problem: err.Error()})
+		return
+	}
+
+	req, code, err := parseAuthorizeRequest(dbClient, redirectURI, r.PostForm)
+	if err != nil {
+		cfg.oauthRedirectError(w, r, req, code, err)
+		return
+	}
+
+	if r.PostForm.Get("decision") != "approve" {
+		cfg.oauthRedirectError(w, r, req, "access_denied", fmt.Errorf("The user denied the request"))
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	page := consentPageData{Request: req, Email: email}
+
+	keys := loginKeys(r, email)
+
+	retryAfter, err := cfg.reserveLoginAttempt(r.Context(), keys)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Authentication failed", err)
+		return
+	}
+
+	if retryAfter > 0 {
+		page.Error = "Too many failed attempts, try again later"
+		sendConsentPage(w, 429, page)
+		return
+	}
+
+	dbUserRow, matches, err := cfg.checkCredentials(r.Context(), email, r.PostForm.Get("password"))
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Authentication failed", err)
+		return
+	}
+
+	if matches && dbUserRow.TotpEnabledAt.Valid {
+		matches, err = cfg.checkSecondFactor(r.Context(), dbUserRow, r.PostForm.Get("totp_code"), "")
+		if err != nil {
+			chirpySendErrorResponse(w, 500, "Authentication failed", err)
+			return
+		}
+	}
+
+	if !matches {
+		err = cfg.recordLoginFailure(r.Context(), keys)
+		if err != nil {
+			log.Printf("Error: %v", err)
+		}
+		page.Error = "Incorrect email, password or code"
+		sendConsentPage(w, 401, page)
+		return
+	}
+
+	err = cfg.releaseLoginAttempt(r.Context(), keys)
+	if err != nil {
+		log.Printf("Error: %v", err)
+	}
+
+	err = cfg.clearLoginFailures(r.Context(), dbUserRow.Email)
+	if err != nil {
+		log.Printf("Error: %v", err)
+	}
+
+	authorizationCode, err := auth.MakeRefreshToken()
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to generate authorization code", err)
+		return
+	}
+
+	err = cfg.dbQueries.CreateAuthorizationCode(r.Context(),
+		database.CreateAuthorizationCodeParams{
+			CodeHash:      auth.HashToken(authorizationCode),
+			ClientID:      dbClient.ID,
+			UserID:        dbUserRow.ID,
+			RedirectUri:   req.RedirectURI,
+			Scopes:        req.Scopes,
+			CodeChallenge: req.CodeChallenge,
+			ExpiresAt:     time.Now().Add(authorizationCodeDuration),
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to store authorization code", err)
+		return
+	}
+
+	params := url.Values{}
+	params.Set("code", authorizationCode)
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	cfg.oauthRedirect(w, r, req.RedirectURI, params)
+}
+
+// Identifies the client calling the token, revocation or introspection
+// endpoint, with HTTP Basic authentication or client_id and client_secret
+// form fields. Public clients only send their client_id
+func (cfg *apiConfig) authenticateOAuthClient(r *http.Request) (database.OauthClient, error) {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		// RFC 6749 section 2.3.1 form encodes both before Basic encoding
+		var err error
+		id, err = url.QueryUnescape(id)
+		if err != nil {
+			return database.OauthClient{}, err
+		}
+		secret, err = url.QueryUnescape(secret)
+		if err != nil {
+			return database.OauthClient{}, err
+		}
+	} else {
+		id = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	clientID, err := uuid.Parse(id)
+	if err != nil {
+		return database.OauthClient{}, fmt.Errorf("Invalid client_id: %w", err)
+	}
+
+	dbClient, err := cfg.dbQueries.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, fmt.Errorf("Unknown client %v: %w", clientID, err)
+	}
+
+	if !dbClient.SecretHash.Valid {
+		if secret != "" {
+			return database.OauthClient{}, fmt.Errorf("Public client %v sent a secret", clientID)
+		}
+		return dbClient, nil
+	}
+
+	hash := auth.HashToken(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(dbClient.SecretHash.String)) != 1 {
+		return database.OauthClient{}, fmt.Errorf("Incorrect secret for client %v", clientID)
+	}
+
+	return dbClient, nil
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// Issues an access token and a refresh token to a client; familyID groups
+// the refresh token with the ones it will be rotated into
+func (cfg *apiConfig) issueOAuthTokens(r *http.Request, userID uuid.UUID,
+	dbClient database.OauthClient, scopes []string, familyID uuid.UUID) (oauthTokenResponse, error) {
+	accessToken, err := cfg.jwtKeys.MakeScopedJWT(userID, dbClient.ID.String(), scopes, cfg.jwtDuration)
+	if err != nil {
+		return oauthTokenResponse{}, fmt.Errorf("Failed to generate access token: %w", err)
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+
+	_, err = cfg.dbQueries.StoreRefreshToken(r.Context(),
+		database.StoreRefreshTokenParams{
+			Token:     refreshToken,
+			UserID:    userID,
+			ExpiresAt: time.Now().Add(refreshTokenDuration),
+			FamilyID:  familyID,
+			UserAgent: clientUserAgent(r),
+			Ip:        clientIP(r),
+			ClientID:  uuid.NullUUID{UUID: dbClient.ID, Valid: true},
+			Scopes:    scopes,
+		})
+	if err != nil {
+		return oauthTokenResponse{}, fmt.Errorf("Failed to store refresh token: %w", err)
+	}
+
+	return oauthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(cfg.jwtDuration / time.Second),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	}, nil
+}
+
+// Exchanges an authorization code or a refresh token for new tokens
+// Requests are form encoded as RFC 6749 requires
+func (cfg *apiConfig) oauthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		sendOAuthError(w, 400, "invalid_request", "Invalid form", err)
+		return
+	}
+
+	dbClient, err := cfg.authenticateOAuthClient(r)
+	if err != nil {
+		sendOAuthError(w, 401, "invalid_client", "Client authentication failed", err)
+		return
+	}
+
+	response := oauthTokenResponse{}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		response, err = cfg.exchangeAuthorizationCode(r, dbClient)
+	case "refresh_token":
+		response, err = cfg.exchangeOAuthRefreshToken(r, dbClient)
+	default:
+		sendOAuthError(w, 400, "unsupported_grant_type", "Unsupported grant type", nil)
+		return
+	}
+
+	var grantErr oauthGrantError
+	if errors.As(err, &grantErr) {
+		sendOAuthError(w, 400, grantErr.code, grantErr.Error(), nil)
+		return
+	}
+	if err != nil {
+		sendOAuthError(w, 500, "server_error", "Failed to issue tokens", err)
+		return
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	chirpySendResponse(w, res)
+}
+
+// A problem with the grant a client presented, as opposed to a server error
+type oauthGrantError struct {
+	code        string
+	description string
+}
+
+func (e oauthGrantError) Error() string {
+	return e.description
+}
+
+func (cfg *apiConfig) exchangeAuthorizationCode(r *http.Request,
+	dbClient database.OauthClient) (oauthTokenResponse, error) {
+	codeHash := auth.HashToken(r.PostForm.Get("code"))
+
+	dbCode, err := cfg.dbQueries.UseAuthorizationCode(r.Context(), codeHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		// A code used twice has probably been stolen, so whatever it was
+		// exchanged for the first time is revoked (RFC 6749 section 4.1.2)
+		dbCode, err := cfg.dbQueries.GetAuthorizationCode(r.Context(), codeHash)
+		if err == nil && dbCode.UsedAt.Valid && dbCode.FamilyID.Valid {
+			log.Printf("Authorization code reuse detected, revoking family %v of user %v",
+				dbCode.FamilyID.UUID, dbCode.UserID)
+
+			err = cfg.dbQueries.RevokeRefreshTokenFamily(r.Context(), dbCode.FamilyID.UUID)
+			if err != nil {
+				return oauthTokenResponse{}, err
+			}
+		}
+		return oauthTokenResponse{}, oauthGrantError{"invalid_grant", "Invalid or expired authorization code"}
+	}
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+
+	if dbCode.ClientID != dbClient.ID {
+		return oauthTokenResponse{}, oauthGrantError{"invalid_grant", "Authorization code was issued to another client"}
+	}
+
+	if r.PostForm.Get("redirect_uri") != dbCode.RedirectUri {
+		return oauthTokenResponse{}, oauthGrantError{"invalid_grant", "Redirect URI doesn't match the authorization request"}
+	}
+
+	err = auth.VerifyPKCE(r.PostForm.Get("code_verifier"), dbCode.CodeChallenge)
+	if err != nil {
+		return oauthTokenResponse{}, oauthGrantError{"invalid_grant", err.Error()}
+	}
+
+	familyID := uuid.New()
+
+	err = cfg.dbQueries.SetAuthorizationCodeFamily(r.Context(),
+		database.SetAuthorizationCodeFamilyParams{
+			CodeHash: codeHash,
+			FamilyID: uuid.NullUUID{UUID: familyID, Valid: true},
+		})
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+
+	return cfg.issueOAuthTokens(r, dbCode.UserID, dbClient, dbCode.Scopes, familyID)
+}
+
+// Rotates a client's refresh token the same way /api/refresh does, and may
+// narrow the scopes of the new tokens
+func (cfg *apiConfig) exchangeOAuthRefreshToken(r *http.Request,
+	dbClient database.OauthClient) (oauthTokenResponse, error) {
+	token := r.PostForm.Get("refresh_token")
+
+	newToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+
+	clientID := uuid.NullUUID{UUID: dbClient.ID, Valid: true}
+
+	dbTokenRow, err := cfg.dbQueries.RotateRefreshToken(r.Context(),
+		database.RotateRefreshTokenParams{
+			ReplacedBy: sql.NullString{String: newToken, Valid: true},
+			Token:      token,
+			ClientID:   clientID,
+		})
+	if errors.Is(err, sql.ErrNoRows) {
+		dbTokenRow, err := cfg.dbQueries.GetRefreshToken(r.Context(), token)
+		if err == nil && dbTokenRow.ClientID == clientID && dbTokenRow.ReplacedBy.Valid {
+			log.Printf("Refresh token reuse detected, revoking family %v of user %v",
+				dbTokenRow.FamilyID, dbTokenRow.UserID)
+
+			err = cfg.dbQueries.RevokeRefreshTokenFamily(r.Context(), dbTokenRow.FamilyID)
+			if err != nil {
+				return oauthTokenResponse{}, err
+			}
+		}
+		return oauthTokenResponse{}, oauthGrantError{"invalid_grant", "Invalid or expired refresh token"}
+	}
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+
+	scopes := dbTokenRow.Scopes
+	requested := strings.Fields(r.PostForm.Get("scope"))
+	if len(requested) > 0 {
+		for _, scope := range requested {
+			if !slices.Contains(dbTokenRow.Scopes, scope) {
+				// The old token was already used up, so the family goes
+				// too rather than leaving the client with nothing valid
+				err = cfg.dbQueries.RevokeRefreshTokenFamily(r.Context(), dbTokenRow.FamilyID)
+				if err != nil {
+					return oauthTokenResponse{}, err
+				}
+				return oauthTokenResponse{}, oauthGrantError{"invalid_scope", "Scope wasn't granted: " + scope}
+			}
+		}
+		scopes, err = auth.ParseScopes(requested)
+		if err != nil {
+			return oauthTokenResponse{}, oauthGrantError{"invalid_scope", err.Error()}
+		}
+	}
+
+	accessToken, err := cfg.jwtKeys.MakeScopedJWT(dbTokenRow.UserID, dbClient.ID.String(), scopes, cfg.jwtDuration)
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+
+	_, err = cfg.dbQueries.StoreRefreshToken(r.Context(),
+		database.StoreRefreshTokenParams{
+			Token:     newToken,
+			UserID:    dbTokenRow.UserID,
+			ExpiresAt: time.Now().Add(refreshTokenDuration),
+			FamilyID:  dbTokenRow.FamilyID,
+			UserAgent: dbTokenRow.UserAgent,
+			Ip:        dbTokenRow.Ip,
+			ClientID:  clientID,
+			Scopes:    scopes,
+		})
+	if err != nil {
+		return oauthTokenResponse{}, fmt.Errorf("Failed to store refresh token: %w", err)
+	}
+
+	return oauthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(cfg.jwtDuration / time.Second),
+		RefreshToken: newToken,
+		Scope:        strings.Join(scopes, " "),
+	}, nil
+}
+
+// RFC 7009 token revocation; clients can only revoke their own tokens
+// Unknown tokens aren't an error since the outcome is the same
+func (cfg *apiConfig) oauthRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		sendOAuthError(w, 400, "invalid_request", "Invalid form", err)
+		return
+	}
+
+	dbClient, err := cfg.authenticateOAuthClient(r)
+	if err != nil {
+		sendOAuthError(w, 401, "invalid_client", "Client authentication failed", err)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+
+	accessToken, err := cfg.jwtKeys.ParseAccessToken(token)
+	if err == nil {
+		if accessToken.ClientID == dbClient.ID.String() {
+			err = cfg.dbQueries.RevokeAccessToken(r.Context(),
+				database.RevokeAccessTokenParams{
+					Jti:       accessToken.ID,
+					UserID:    accessToken.UserID,
+					ExpiresAt: accessToken.ExpiresAt,
+				})
+			if err != nil {
+				sendOAuthError(w, 500, "server_error", "Failed to revoke token", err)
+				return
+			}
+		}
+	} else {
+		dbTokenRow, err := cfg.dbQueries.GetRefreshToken(r.Context(), token)
+		if err == nil && dbTokenRow.ClientID.Valid && dbTokenRow.ClientID.UUID == dbClient.ID {
+			err = cfg.dbQueries.RevokeRefreshTokenFamily(r.Context(), dbTokenRow.FamilyID)
+			if err != nil {
+				sendOAuthError(w, 500, "server_error", "Failed to revoke token", err)
+				return
+			}
+		}
+	}
+
+	w.WriteHeader(200)
+	w.Write([]byte{})
+}
+
+type oauthIntrospection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ID        string `json:"jti,omitempty"`
+}
+
+// RFC 7662 token introspection for confidential clients, about their own
+// tokens; any other token is reported inactive
+func (cfg *apiConfig) oauthIntrospectHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		sendOAuthError(w, 400, "invalid_request", "Invalid form", err)
+		return
+	}
+
+	dbClient, err := cfg.authenticateOAuthClient(r)
+	if err != nil {
+		sendOAuthError(w, 401, "invalid_client", "Client authentication failed", err)
+		return
+	}
+
+	if !dbClient.SecretHash.Valid {
+		sendOAuthError(w, 401, "invalid_client", "Public clients can't introspect tokens", nil)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	response := oauthIntrospection{}
+
+	accessToken, err := cfg.validateAccessToken(r.Context(), token)
+	if err == nil {
+		if accessToken.ClientID == dbClient.ID.String() {
+			response = oauthIntrospection{
+				Active:    true,
+				Scope:     strings.Join(accessToken.Scopes, " "),
+				ClientID:  accessToken.ClientID,
+				Subject:   accessToken.UserID.String(),
+				TokenType: "access_token",
+				ExpiresAt: accessToken.ExpiresAt.Unix(),
+				IssuedAt:  accessToken.IssuedAt.Unix(),
+				ID:        accessToken.ID,
+			}
+		}
+	} else {
+		dbTokenRow, err := cfg.dbQueries.GetRefreshToken(r.Context(), token)
+		if err == nil &&
+			dbTokenRow.ClientID.Valid && dbTokenRow.ClientID.UUID == dbClient.ID &&
+			!dbTokenRow.RevokedAt.Valid && dbTokenRow.ExpiresAt.After(time.Now()) {
+			response = oauthIntrospection{
+				Active:    true,
+				Scope:     strings.Join(dbTokenRow.Scopes, " "),
+				ClientID:  dbClient.ID.String(),
+				Subject:   dbTokenRow.UserID.String(),
+				TokenType: "refresh_token",
+				ExpiresAt: dbTokenRow.ExpiresAt.Unix(),
+				IssuedAt:  dbTokenRow.CreatedAt.Unix(),
+			}
+		}
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	chirpySendResponse(w, res)
+}
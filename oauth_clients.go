@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/auth"
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+)
+
+const (
+	maxOAuthClientNameLength   = 100
+	maxOAuthClientRedirectURIs = 10
+)
+
+type oauthClient struct {
+	ID           string   `json:"client_id"`
+	CreatedAt    string   `json:"created_at"`
+	Name         string   `json:"name"`
+	Public       bool     `json:"public"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	// Only set when a confidential client is registered
+	Secret string `json:"client_secret,omitempty"`
+}
+
+func oauthClientFromDatabase(c database.OauthClient) oauthClient {
+	return oauthClient{
+		ID:           c.ID.String(),
+		CreatedAt:    c.CreatedAt.String(),
+		Name:         c.Name,
+		Public:       !c.SecretHash.Valid,
+		RedirectURIs: c.RedirectUris,
+		Scopes:       c.Scopes,
+	}
+}
+
+// Registers a third-party app owned by the caller
+// Public clients, such as mobile and single page apps, can't keep a secret
+// and get none; the client secret of a confidential client is only shown
+// in this response
+func (cfg *apiConfig) oauthClientCreateHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	req := struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Public       bool     `json:"public"`
+	}{}
+
+	err = chirpyDecodeJsonRequest(r, &req)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if len(name) == 0 || utf8.RuneCountInString(name) > maxOAuthClientNameLength {
+		chirpySendErrorResponse(w, 400, "Name must be 1-100 characters", nil)
+		return
+	}
+
+	if len(req.RedirectURIs) == 0 || len(req.RedirectURIs) > maxOAuthClientRedirectURIs {
+		chirpySendErrorResponse(w, 400, "Between 1 and 10 redirect URIs are required", nil)
+		return
+	}
+
+	for _, uri := range req.RedirectURIs {
+		err = auth.ValidateRedirectURI(uri)
+		if err != nil {
+			chirpySendErrorResponse(w, 400, "Invalid redirect URI", err)
+			return
+		}
+	}
+
+	scopes, err := auth.ParseScopes(req.Scopes)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid scopes", err)
+		return
+	}
+
+	secret := ""
+	secretHash := sql.NullString{}
+	if !req.Public {
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			chirpySendErrorResponse(w, 500, "Failed to generate client secret", err)
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	dbClient, err := cfg.dbQueries.CreateOAuthClient(r.Context(),
+		database.CreateOAuthClientParams{
+			OwnerID:      userID,
+			Name:         name,
+			SecretHash:   secretHash,
+			RedirectUris: req.RedirectURIs,
+			Scopes:       scopes,
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to register client", err)
+		return
+	}
+
+	response := oauthClientFromDatabase(dbClient)
+	response.Secret = secret
+
+	res, err := chirpyEncodeJsonResponse(201, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+func (cfg *apiConfig) oauthClientsGetHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	dbClients, err := cfg.dbQueries.ListOAuthClients(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get clients", err)
+		return
+	}
+
+	response := []oauthClient{}
+	for _, c := range dbClients {
+		response = append(response, oauthClientFromDatabase(c))
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+// Deletes a client along with its refresh tokens; access tokens already
+// issued to it run until they expire
+func (cfg *apiConfig) oauthClientDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	clientID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "Client not found", err)
+		return
+	}
+
+	rows, err := cfg.dbQueries.DeleteOAuthClient(r.Context(),
+		database.DeleteOAuthClientParams{
+			ID:      clientID,
+			OwnerID: userID,
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to delete client", err)
+		return
+	}
+
+	if rows == 0 {
+		chirpySendErrorResponse(w, 404, "Client not found", nil)
+		return
+	}
+
+	w.WriteHeader(204)
+	w.Write([]byte{})
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/auth"
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+	"github.com/Tavis7/bootdev-chirpy/internal/oidc"
+)
+
+const (
+	oidcLoginDuration = 10 * time.Minute
+	oidcStateCookie   = "chirpy_oidc_state"
+	oidcCallbackPath  = "/api/oidc/callback"
+)
+
+var errIdentityEmailUnverified = errors.New("Identity provider hasn't verified the email address")
+
+// Logging in with an OpenID Connect provider is enabled by setting
+// OIDC_ISSUER, along with OIDC_CLIENT_ID and OIDC_CLIENT_SECRET from
+// registering Chirpy with the provider
+func loadOIDCProvider(publicURL string) (*oidc.Provider, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	if clientID == "" {
+		return nil, fmt.Errorf("OIDC_CLIENT_ID is required with OIDC_ISSUER")
+	}
+
+	return &oidc.Provider{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  publicURL + oidcCallbackPath,
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+		Leeway:       auth.DefaultLeeway,
+	}, nil
+}
+
+func (cfg *apiConfig) setOIDCStateCookie(w http.ResponseWriter, value string, maxAge int) {
+	// Lax so the cookie comes back on the provider's redirect
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/api/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.publicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Sends the browser to the identity provider to sign in
+// The state is also kept in a cookie, so a callback can only complete a
+// login started in the same browser
+func (cfg *apiConfig) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidcProvider == nil {
+		chirpySendErrorResponse(w, 404, "OIDC login not configured", nil)
+		return
+	}
+
+	state, err := auth.MakeRefreshToken()
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to start login", err)
+		return
+	}
+	nonce, err := auth.MakeRefreshToken()
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to start login", err)
+		return
+	}
+	verifier, err := auth.MakeRefreshToken()
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to start login", err)
+		return
+	}
+
+	authURL, err := cfg.oidcProvider.AuthCodeURL(r.Context(), state, nonce, auth.PKCEChallenge(verifier))
+	if err != nil {
+		chirpySendErrorResponse(w, 502, "Identity provider unavailable", err)
+		return
+	}
+
+	err = cfg.dbQueries.CreateOIDCLoginState(r.Context(),
+		database.CreateOIDCLoginStateParams{
+			StateHash:    auth.HashToken(state),
+			Nonce:        nonce,
+			CodeVerifier: verifier,
+			ExpiresAt:    time.Now().Add(oidcLoginDuration),
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to start login", err)
+		return
+	}
+
+	// Logins that were never finished would otherwise pile up
+	err = cfg.dbQueries.DeleteExpiredOIDCLoginStates(r.Context())
+	if err != nil {
+		log.Printf("Error: Failed to delete expired login states: %v", err)
+	}
+
+	cfg.setOIDCStateCookie(w, state, int(oidcLoginDuration/time.Second))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Where the identity provider sends the browser back to; responds like
+// /api/login
+func (cfg *apiConfig) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidcProvider == nil {
+		chirpySendErrorResponse(w, 404, "OIDC login not configured", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	state := query.Get("state")
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || len(state) == 0 ||
+		subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		chirpySendErrorResponse(w, 400, "Invalid login state", err)
+		return
+	}
+	cfg.setOIDCStateCookie(w, "", -1)
+
+	dbState, err := cfg.dbQueries.UseOIDCLoginState(r.Context(), auth.HashToken(state))
+	if errors.Is(err, sql.ErrNoRows) {
+		chirpySendErrorResponse(w, 400, "Login expired, try again", err)
+		return
+	}
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Authentication failed", err)
+		return
+	}
+
+	if query.Has("error") {
+		chirpySendErrorResponse(w, 401, "Identity provider refused the login",
+			fmt.Errorf("%v: %v", query.Get("error"), query.Get("error_description")))
+		return
+	}
+
+	identity, err := cfg.oidcProvider.Exchange(r.Context(), query.Get("code"),
+		dbState.CodeVerifier, dbState.Nonce)
+	if err != nil {
+		chirpySendErrorResponse(w, 401, "Identity provider login failed", err)
+		return
+	}
+
+	dbUserRow, err := cfg.userForIdentity(r.Context(), identity)
+	if errors.Is(err, errIdentityEmailUnverified) {
+		chirpySendErrorResponse(w, 403, err.Error(), err)
+		return
+	}
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Authentication failed", err)
+		return
+	}
+
+	if dbUserRow.TotpEnabledAt.Valid {
+		cfg.sendMFAChallenge(w, dbUserRow)
+		return
+	}
+
+	cfg.completeLogin(w, r, dbUserRow)
+}
+
+// Finds the user an identity is linked to, or links it to the user with the
+// same email address, creating one if there is none
+// Only addresses the provider has verified are trusted for linking
+func (cfg *apiConfig) userForIdentity(ctx context.Context, identity oidc.Identity) (database.User, error) {
+	dbUserRow, err := cfg.dbQueries.GetUserByIdentity(ctx,
+		database.GetUserByIdentityParams{
+			Issuer:  identity.Issuer,
+			Subject: identity.Subject,
+		})
+	if err == nil {
+		return dbUserRow, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if !identity.EmailVerified || identity.Email == "" {
+		return database.User{}, errIdentityEmailUnverified
+	}
+
+	dbUserRow, err = cfg.dbQueries.GetUserByEmail(ctx, identity.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		dbUserRow, err = cfg.createIdentityUser(ctx, identity.Email)
+	} else if err == nil && !dbUserRow.EmailVerifiedAt.Valid {
+		err = cfg.claimUnverifiedAccount(ctx, dbUserRow)
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+
+	err = cfg.dbQueries.CreateUserIdentity(ctx,
+		database.CreateUserIdentityParams{
+			Issuer:  identity.Issuer,
+			Subject: identity.Subject,
+			UserID:  dbUserRow.ID,
+			Email:   identity.Email,
+		})
+	if err != nil {
+		return database.User{}, fmt.Errorf("Failed to link identity: %w", err)
+	}
+
+	_, err = cfg.dbQueries.VerifyUserEmail(ctx,
+		database.VerifyUserEmailParams{
+			ID:    dbUserRow.ID,
+			Email: identity.Email,
+		})
+	if err != nil {
+		return database.User{}, fmt.Errorf("Failed to verify email: %w", err)
+	}
+
+	return cfg.dbQueries.GetUserByID(ctx, dbUserRow.ID)
+}
+
+// Users created by logging in with a provider get a random password; they
+// can set one with a password reset
+func (cfg *apiConfig) createIdentityUser(ctx context.Context, email string) (database.User, error) {
+	password, err := auth.MakeRefreshToken()
+	if err != nil {
+		return database.User{}, err
+	}
+
+	passwordHash, err := auth.HashPassword(password)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	dbUserRow, err := cfg.dbQueries.CreateUser(ctx,
+		database.CreateUserParams{
+			Email:          email,
+			HashedPassword: passwordHash,
+		})
+	if err != nil {
+		// Signed up another way in the meantime
+		e, ok := err.(*pq.Error)
+		if ok && e.Code.Name() == "unique_violation" && e.Constraint == "users_email_key" {
+			return cfg.dbQueries.GetUserByEmail(ctx, email)
+		}
+		return database.User{}, fmt.Errorf("Failed to create user: %w", err)
+	}
+
+	return dbUserRow, nil
+}
+
+// Anyone could have signed up with an address they don't own, so when the
+// provider proves who does, the password, second factor, passkeys, sessions
+// and personal access tokens set up before are thrown away
+func (cfg *apiConfig) claimUnverifiedAccount(ctx context.Context, dbUser database.User) error {
+	password, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+
+	passwordHash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = cfg.dbQueries.UpdateUser(ctx,
+		database.UpdateUserParams{
+			ID:             dbUser.ID,
+			HashedPassword: sql.NullString{String: passwordHash, Valid: true},
+		})
+	if err != nil {
+		return fmt.Errorf("Failed to reset password: %w", err)
+	}
+
+	err = cfg.dbQueries.DisableTOTP(ctx, dbUser.ID)
+	if err != nil {
+		return fmt.Errorf("Failed to disable two-factor authentication: %w", err)
+	}
+
+	err = cfg.dbQueries.DeleteRecoveryCodes(ctx, dbUser.ID)
+	if err != nil {
+		return fmt.Errorf("Failed to delete recovery codes: %w", err)
+	}
+
+	err = cfg.dbQueries.DeleteUserWebAuthnCredentials(ctx, dbUser.ID)
+	if err != nil {
+		return fmt.Errorf("Failed to delete passkeys: %w", err)
+	}
+
+	return cfg.revokeUserTokens(ctx, dbUser.ID)
+}
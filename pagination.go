@@ -0,0 +1,192 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// A position in a listing ordered by (created_at, id)
+// Ranked listings such as search results order by (rank, created_at, id)
+// Prev marks a cursor that pages backwards from the position
+type pageCursor struct {
+	Rank      float32   `json:"rank,omitempty"`
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+	Prev      bool      `json:"prev,omitempty"`
+}
+
+func (c pageCursor) encode() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		// Only fails for unencodable types, which pageCursor doesn't have
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePageCursor(s string) (pageCursor, error) {
+	c := pageCursor{}
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("Failed to decode cursor: %w", err)
+	}
+
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return c, fmt.Errorf("Failed to decode cursor: %w", err)
+	}
+
+	if c.CreatedAt.IsZero() || c.ID == uuid.Nil {
+		return c, fmt.Errorf("Incomplete cursor: %v", s)
+	}
+
+	return c, nil
+}
+
+type pageRequest struct {
+	limit  int32
+	cursor *pageCursor
+}
+
+func parsePageRequest(r *http.Request) (pageRequest, error) {
+	page := pageRequest{limit: defaultPageLimit}
+
+	limit := r.URL.Query().Get("limit")
+	if len(limit) > 0 {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return page, fmt.Errorf("Invalid limit: %w", err)
+		}
+		if n < 1 || n > maxPageLimit {
+			return page, fmt.Errorf("Limit out of range: %v", n)
+		}
+		page.limit = int32(n)
+	}
+
+	cursor := r.URL.Query().Get("cursor")
+	if len(cursor) > 0 {
+		c, err := decodePageCursor(cursor)
+		if err != nil {
+			return page, err
+		}
+		page.cursor = &c
+	}
+
+	return page, nil
+}
+
+// One extra row is fetched to find out whether there is another page
+func (p pageRequest) fetchLimit() int32 {
+	return p.limit + 1
+}
+
+// Whether the database should be scanned in ascending (created_at, id) order
+// for a listing that is presented in the given order
+func (p pageRequest) scanAscending(sortAsc bool) bool {
+	return sortAsc != p.backwards()
+}
+
+func (p pageRequest) backwards() bool {
+	return p.cursor != nil && p.cursor.Prev
+}
+
+func (p pageRequest) cursorRank() sql.NullFloat64 {
+	if p.cursor == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: float64(p.cursor.Rank), Valid: true}
+}
+
+func (p pageRequest) cursorCreatedAt() sql.NullTime {
+	if p.cursor == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: p.cursor.CreatedAt, Valid: true}
+}
+
+func (p pageRequest) cursorID() uuid.NullUUID {
+	if p.cursor == nil {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: p.cursor.ID, Valid: true}
+}
+
+// Trims the extra row fetched by fetchLimit, restores presentation order for
+// backwards pages and returns cursors for the neighbouring pages, if any
+func paginate[T any](items []T, page pageRequest, position func(T) pageCursor) ([]T, *pageCursor, *pageCursor) {
+	hasMore := len(items) > int(page.limit)
+	if hasMore {
+		items = items[:page.limit]
+	}
+
+	if page.backwards() {
+		slices.Reverse(items)
+	}
+
+	if len(items) == 0 {
+		return items, nil, nil
+	}
+
+	var next, prev *pageCursor
+
+	if hasMore || page.backwards() {
+		c := position(items[len(items)-1])
+		next = &c
+	}
+
+	if page.cursor != nil && (hasMore || !page.backwards()) {
+		c := position(items[0])
+		c.Prev = true
+		prev = &c
+	}
+
+	return items, next, prev
+}
+
+// Sets an RFC 8288 Link header pointing at the neighbouring pages
+// The current query string is kept so filters carry over
+func setPageLinks(w http.ResponseWriter, r *http.Request, next, prev *pageCursor) {
+	links := []string{}
+
+	for _, l := range []struct {
+		rel    string
+		cursor *pageCursor
+	}{
+		{"next", next},
+		{"prev", prev},
+	} {
+		if l.cursor == nil {
+			continue
+		}
+		query := r.URL.Query()
+		query.Set("cursor", l.cursor.encode())
+		links = append(links, fmt.Sprintf(`<%v?%v>; rel="%v"`,
+			r.URL.Path, query.Encode(), l.rel))
+	}
+
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+func encodePageCursor(c *pageCursor) string {
+	if c == nil {
+		return ""
+	}
+	return c.encode()
+}
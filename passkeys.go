@@ -0,0 +1,527 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+	"github.com/Tavis7/bootdev-chirpy/internal/mailer"
+	"github.com/Tavis7/bootdev-chirpy/internal/webauthn"
+)
+
+const (
+	webauthnCeremonyDuration = 5 * time.Minute
+	maxPasskeyNameLength     = 100
+
+	webauthnCeremonyCreate = "webauthn.create"
+	webauthnCeremonyGet    = "webauthn.get"
+)
+
+// Passkeys are scoped to PUBLIC_URL's origin and, unless WEBAUTHN_RP_ID
+// names a parent domain, its host
+func loadRelyingParty(publicURL string) (webauthn.RelyingParty, error) {
+	u, err := url.Parse(publicURL)
+	if err != nil || u.Host == "" {
+		return webauthn.RelyingParty{}, fmt.Errorf("Invalid PUBLIC_URL: %v", publicURL)
+	}
+
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = u.Hostname()
+	}
+
+	host := u.Hostname()
+	if host != rpID && !strings.HasSuffix(host, "."+rpID) {
+		return webauthn.RelyingParty{}, fmt.Errorf("WEBAUTHN_RP_ID %v doesn't cover %v", rpID, host)
+	}
+
+	return webauthn.RelyingParty{
+		ID:     rpID,
+		Name:   "Chirpy",
+		Origin: u.Scheme + "://" + u.Host,
+	}, nil
+}
+
+type passkey struct {
+	ID             string `json:"id"`
+	CreatedAt      string `json:"created_at"`
+	Name           string `json:"name"`
+	BackupEligible bool   `json:"backup_eligible"`
+	LastUsedAt     string `json:"last_used_at,omitempty"`
+}
+
+func passkeyFromDatabase(c database.WebauthnCredential) passkey {
+	response := passkey{
+		ID:             c.ID.String(),
+		CreatedAt:      c.CreatedAt.String(),
+		Name:           c.Name,
+		BackupEligible: c.BackupEligible,
+	}
+	if c.LastUsedAt.Valid {
+		response.LastUsedAt = c.LastUsedAt.Time.String()
+	}
+	return response
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// Issues a challenge for a ceremony; userID is set when registering
+func (cfg *apiConfig) createWebAuthnChallenge(ctx context.Context, userID uuid.NullUUID,
+	ceremony string) (string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", err
+	}
+	encoded := webauthn.EncodeBase64(challenge)
+
+	err = cfg.dbQueries.CreateWebAuthnChallenge(ctx,
+		database.CreateWebAuthnChallengeParams{
+			Challenge: encoded,
+			UserID:    userID,
+			Ceremony:  ceremony,
+			ExpiresAt: time.Now().Add(webauthnCeremonyDuration),
+		})
+	if err != nil {
+		return "", fmt.Errorf("Failed to store challenge: %w", err)
+	}
+
+	// Ceremonies that were never finished would otherwise pile up
+	err = cfg.dbQueries.DeleteExpiredWebAuthnChallenges(ctx)
+	if err != nil {
+		log.Printf("Error: Failed to delete expired challenges: %v", err)
+	}
+
+	return encoded, nil
+}
+
+// Uses up the challenge a client answered
+func (cfg *apiConfig) useWebAuthnChallenge(ctx context.Context, clientDataJSON []byte,
+	ceremony string) (database.WebauthnChallenge, []byte, error) {
+	challenge, err := webauthn.ClientDataChallenge(clientDataJSON)
+	if err != nil {
+		return database.WebauthnChallenge{}, nil, err
+	}
+
+	dbChallenge, err := cfg.dbQueries.UseWebAuthnChallenge(ctx,
+		database.UseWebAuthnChallengeParams{
+			Challenge: webauthn.EncodeBase64(challenge),
+			Ceremony:  ceremony,
+		})
+	if err != nil {
+		return database.WebauthnChallenge{}, nil, fmt.Errorf("Unknown or expired challenge: %w", err)
+	}
+
+	return dbChallenge, challenge, nil
+}
+
+// Options for navigator.credentials.create(), in the JSON form
+// PublicKeyCredential.parseCreationOptionsFromJSON() takes
+func (cfg *apiConfig) passkeyRegisterBeginHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	dbUserRow, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 401, "Authorization failed", err)
+		return
+	}
+
+	dbCredentials, err := cfg.dbQueries.ListWebAuthnCredentials(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to start registration", err)
+		return
+	}
+
+	challenge, err := cfg.createWebAuthnChallenge(r.Context(),
+		uuid.NullUUID{UUID: userID, Valid: true}, webauthnCeremonyCreate)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to start registration", err)
+		return
+	}
+
+	type relyingParty struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	type user struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	}
+	type authenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	}
+
+	response := struct {
+		Challenge              string                 `json:"challenge"`
+		RP                     relyingParty           `json:"rp"`
+		User                   user                   `json:"user"`
+		PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+		Timeout                int64                  `json:"timeout"`
+		ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+		AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+		Attestation            string                 `json:"attestation"`
+	}{
+		Challenge: challenge,
+		RP: relyingParty{
+			ID:   cfg.relyingParty.ID,
+			Name: cfg.relyingParty.Name,
+		},
+		User: user{
+			ID:          webauthn.EncodeBase64(userID[:]),
+			Name:        dbUserRow.Email,
+			DisplayName: dbUserRow.DisplayName,
+		},
+		PubKeyCredParams:   []credentialParameter{},
+		Timeout:            webauthnCeremonyDuration.Milliseconds(),
+		ExcludeCredentials: []credentialDescriptor{},
+		// Passkeys are discoverable so logging in doesn't need an email
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}
+
+	for _, alg := range webauthn.SupportedAlgorithms {
+		response.PubKeyCredParams = append(response.PubKeyCredParams,
+			credentialParameter{Type: "public-key", Alg: alg})
+	}
+
+	for _, c := range dbCredentials {
+		response.ExcludeCredentials = append(response.ExcludeCredentials,
+			credentialDescriptor{Type: "public-key", ID: webauthn.EncodeBase64(c.CredentialID)})
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+// Stores the passkey an authenticator created, from the JSON form of the
+// PublicKeyCredential
+// A passkey logs in without the password or second factor, so both are asked
+// for again; otherwise a stolen access token could add one and keep the
+// account for good
+func (cfg *apiConfig) passkeyRegisterFinishHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	req := struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+		Name         string `json:"name"`
+		Response     struct {
+			ClientDataJSON    string `json:"clientDataJSON"`
+			AttestationObject string `json:"attestationObject"`
+		} `json:"response"`
+	}{}
+
+	err = chirpyDecodeJsonRequest(r, &req)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if len(name) == 0 {
+		name = "Passkey"
+	}
+	if utf8.RuneCountInString(name) > maxPasskeyNameLength {
+		chirpySendErrorResponse(w, 400, "Name must be at most 100 characters", nil)
+		return
+	}
+
+	clientDataJSON, err := webauthn.DecodeBase64(req.Response.ClientDataJSON)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid client data", err)
+		return
+	}
+
+	attestationObject, err := webauthn.DecodeBase64(req.Response.AttestationObject)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid attestation object", err)
+		return
+	}
+
+	dbUserRow, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 401, "Authorization failed", err)
+		return
+	}
+
+	if !cfg.reauthenticate(w, r, dbUserRow, req.Password, req.Code, req.RecoveryCode) {
+		return
+	}
+
+	dbChallenge, challenge, err := cfg.useWebAuthnChallenge(r.Context(), clientDataJSON, webauthnCeremonyCreate)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Registration failed", err)
+		return
+	}
+
+	if !dbChallenge.UserID.Valid || dbChallenge.UserID.UUID != userID {
+		chirpySendErrorResponse(w, 400, "Registration failed", fmt.Errorf("Challenge was issued to another user"))
+		return
+	}
+
+	credential, err := cfg.relyingParty.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Registration failed", err)
+		return
+	}
+
+	dbCredential, err := cfg.dbQueries.CreateWebAuthnCredential(r.Context(),
+		database.CreateWebAuthnCredentialParams{
+			UserID:         userID,
+			Name:           name,
+			CredentialID:   credential.ID,
+			PublicKey:      credential.PublicKey,
+			SignCount:      int64(credential.SignCount),
+			Aaguid:         credential.AAGUID,
+			BackupEligible: credential.BackupEligible,
+		})
+	if err != nil {
+		e, ok := err.(*pq.Error)
+		if ok && e.Code.Name() == "unique_violation" {
+			chirpySendErrorResponse(w, 409, "Passkey already registered", e)
+			return
+		}
+		chirpySendErrorResponse(w, 500, "Failed to store passkey", err)
+		return
+	}
+
+	// Lets the owner notice if someone else managed to add one
+	err = cfg.mailer.Send(r.Context(), mailer.Message{
+		To:      dbUserRow.Email,
+		Subject: "A passkey was added to your Chirpy account",
+		Body: fmt.Sprintf("The passkey \"%v\" can now be used to log in to your Chirpy account.\n\n"+
+			"If you didn't add it, remove it from your account settings and change your password.\n",
+			name),
+	})
+	if err != nil {
+		log.Printf("Error: Failed to send passkey notice: %v", err)
+	}
+
+	res, err := chirpyEncodeJsonResponse(201, passkeyFromDatabase(dbCredential))
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+func (cfg *apiConfig) passkeysGetHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	dbCredentials, err := cfg.dbQueries.ListWebAuthnCredentials(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get passkeys", err)
+		return
+	}
+
+	response := []passkey{}
+	for _, c := range dbCredentials {
+		response = append(response, passkeyFromDatabase(c))
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+func (cfg *apiConfig) passkeyDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	credentialID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "Passkey not found", err)
+		return
+	}
+
+	rows, err := cfg.dbQueries.DeleteWebAuthnCredential(r.Context(),
+		database.DeleteWebAuthnCredentialParams{
+			ID:     credentialID,
+			UserID: userID,
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to delete passkey", err)
+		return
+	}
+
+	if rows == 0 {
+		chirpySendErrorResponse(w, 404, "Passkey not found", nil)
+		return
+	}
+
+	w.WriteHeader(204)
+	w.Write([]byte{})
+}
+
+// Options for navigator.credentials.get(); no credentials are listed, so the
+// browser offers whichever passkeys the user has for Chirpy
+func (cfg *apiConfig) passkeyLoginBeginHandler(w http.ResponseWriter, r *http.Request) {
+	challenge, err := cfg.createWebAuthnChallenge(r.Context(), uuid.NullUUID{}, webauthnCeremonyGet)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to start login", err)
+		return
+	}
+
+	response := struct {
+		Challenge        string                 `json:"challenge"`
+		RPID             string                 `json:"rpId"`
+		Timeout          int64                  `json:"timeout"`
+		AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+		UserVerification string                 `json:"userVerification"`
+	}{
+		Challenge:        challenge,
+		RPID:             cfg.relyingParty.ID,
+		Timeout:          webauthnCeremonyDuration.Milliseconds(),
+		AllowCredentials: []credentialDescriptor{},
+		UserVerification: "required",
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+// Logs in with a passkey, responding like /api/login
+// The passkey verified the user itself, so no second factor is asked for
+func (cfg *apiConfig) passkeyLoginFinishHandler(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		RawID    string `json:"rawId"`
+		Response struct {
+			ClientDataJSON    string `json:"clientDataJSON"`
+			AuthenticatorData string `json:"authenticatorData"`
+			Signature         string `json:"signature"`
+			UserHandle        string `json:"userHandle"`
+		} `json:"response"`
+	}{}
+
+	err := chirpyDecodeJsonRequest(r, &req)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		return
+	}
+
+	fields := []string{req.RawID, req.Response.ClientDataJSON, req.Response.AuthenticatorData,
+		req.Response.Signature, req.Response.UserHandle}
+	decoded := make([][]byte, len(fields))
+	for i, field := range fields {
+		decoded[i], err = webauthn.DecodeBase64(field)
+		if err != nil {
+			chirpySendErrorResponse(w, 400, "Invalid request", err)
+			return
+		}
+	}
+	rawID, clientDataJSON, authenticatorData, signature, userHandle :=
+		decoded[0], decoded[1], decoded[2], decoded[3], decoded[4]
+
+	_, challenge, err := cfg.useWebAuthnChallenge(r.Context(), clientDataJSON, webauthnCeremonyGet)
+	if err != nil {
+		chirpySendErrorResponse(w, 401, "Authentication failed", err)
+		return
+	}
+
+	dbCredential, err := cfg.dbQueries.GetWebAuthnCredential(r.Context(), rawID)
+	if errors.Is(err, sql.ErrNoRows) {
+		chirpySendErrorResponse(w, 401, "Authentication failed", fmt.Errorf("Unknown credential"))
+		return
+	}
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Authentication failed", err)
+		return
+	}
+
+	// Discoverable credentials say which user they were created for
+	if len(userHandle) > 0 && !bytes.Equal(userHandle, dbCredential.UserID[:]) {
+		chirpySendErrorResponse(w, 401, "Authentication failed", fmt.Errorf("User handle doesn't match"))
+		return
+	}
+
+	signCount, err := cfg.relyingParty.VerifyAssertion(challenge, clientDataJSON, authenticatorData,
+		signature, dbCredential.PublicKey, uint32(dbCredential.SignCount))
+	if errors.Is(err, webauthn.ErrSignCountRegressed) {
+		log.Printf("Passkey %v of user %v may have been cloned: %v",
+			dbCredential.ID, dbCredential.UserID, err)
+	}
+	if err != nil {
+		chirpySendErrorResponse(w, 401, "Authentication failed", err)
+		return
+	}
+
+	rows, err := cfg.dbQueries.UseWebAuthnCredential(r.Context(),
+		database.UseWebAuthnCredentialParams{
+			ID:           dbCredential.ID,
+			NewSignCount: int64(signCount),
+			OldSignCount: dbCredential.SignCount,
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Authentication failed", err)
+		return
+	}
+
+	if rows == 0 {
+		chirpySendErrorResponse(w, 401, "Authentication failed", fmt.Errorf("Credential was used concurrently"))
+		return
+	}
+
+	dbUserRow, err := cfg.dbQueries.GetUserByID(r.Context(), dbCredential.UserID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Authentication failed", err)
+		return
+	}
+
+	cfg.completeLogin(w, r, dbUserRow)
+}
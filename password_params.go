@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/auth"
+)
+
+// Hashes slower than this make logins slow and pile up under load
+const slowPasswordHash = time.Second
+
+// Reads ARGON2_MEMORY_KIB, ARGON2_ITERATIONS and ARGON2_PARALLELISM over
+// the defaults
+func loadPasswordParams() (auth.PasswordParams, error) {
+	params := auth.DefaultPasswordParams
+
+	settings := []struct {
+		env   string
+		value *uint32
+	}{
+		{"ARGON2_MEMORY_KIB", &params.Memory},
+		{"ARGON2_ITERATIONS", &params.Iterations},
+	}
+
+	for _, setting := range settings {
+		s := os.Getenv(setting.env)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return params, fmt.Errorf("Invalid %v: %w", setting.env, err)
+		}
+		*setting.value = uint32(n)
+	}
+
+	s := os.Getenv("ARGON2_PARALLELISM")
+	if s != "" {
+		n, err := strconv.ParseUint(s, 10, 8)
+		if err != nil {
+			return params, fmt.Errorf("Invalid ARGON2_PARALLELISM: %w", err)
+		}
+		params.Parallelism = uint8(n)
+	}
+
+	err := auth.ValidatePasswordParams(params)
+	if err != nil {
+		return params, fmt.Errorf("Invalid Argon2 parameters: %w", err)
+	}
+
+	return params, nil
+}
+
+// Times one hash so a poor choice of parameters shows up in the log rather
+// than as slow logins
+func benchmarkPasswordParams(params auth.PasswordParams) {
+	elapsed, err := auth.TimePasswordHash(params)
+	if err != nil {
+		log.Printf("Error: Failed to benchmark password hashing: %v", err)
+		return
+	}
+
+	fmt.Printf("Password hashing: m=%vKiB t=%v p=%v takes %v\n",
+		params.Memory, params.Iterations, params.Parallelism, elapsed)
+
+	if elapsed > slowPasswordHash {
+		fmt.Printf("Warning: password hashing takes over %v; "+
+			"consider lowering ARGON2_MEMORY_KIB or ARGON2_ITERATIONS\n", slowPasswordHash)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/passwordpolicy"
+)
+
+// BREACHED_PASSWORDS_FILE is a list of SHA-1 hashes of leaked passwords
+// sorted by hash, such as the Pwned Passwords download ordered by hash
+// It is searched in place, so the full list doesn't need to fit in memory
+func loadPasswordPolicy() (passwordpolicy.Policy, error) {
+	policy := passwordpolicy.Default()
+
+	path := os.Getenv("BREACHED_PASSWORDS_FILE")
+	if path != "" {
+		list, err := passwordpolicy.OpenHashFile(path)
+		if err != nil {
+			return policy, err
+		}
+		policy.Breached = list
+	}
+
+	return policy, nil
+}
+
+// Checks a new password against the policy, sending every rule it breaks to
+// the client if it is rejected
+func (cfg *apiConfig) checkPasswordPolicy(w http.ResponseWriter, password string) bool {
+	err := cfg.passwordPolicy.Check(password)
+	if err == nil {
+		return true
+	}
+
+	var policyErr *passwordpolicy.Error
+	if !errors.As(err, &policyErr) {
+		chirpySendErrorResponse(w, 500, "Failed to check password", err)
+		return false
+	}
+
+	response := struct {
+		Reason     string                     `json:"error"`
+		Violations []passwordpolicy.Violation `json:"violations"`
+	}{
+		Reason:     "Password does not meet requirements",
+		Violations: policyErr.Violations,
+	}
+
+	res, err := chirpyEncodeJsonResponse(400, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+	return false
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/auth"
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+)
+
+const (
+	maxPersonalAccessTokenNameLength = 100
+	maxPersonalAccessTokenDays       = 366
+)
+
+type personalAccessToken struct {
+	ID         string   `json:"id"`
+	CreatedAt  string   `json:"created_at"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	ExpiresAt  string   `json:"expires_at,omitempty"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	// Only set when the token is created
+	Token string `json:"token,omitempty"`
+}
+
+func personalAccessTokenFromDatabase(t database.PersonalAccessToken) personalAccessToken {
+	response := personalAccessToken{
+		ID:        t.ID.String(),
+		CreatedAt: t.CreatedAt.String(),
+		Name:      t.Name,
+		Scopes:    t.Scopes,
+	}
+	if t.ExpiresAt.Valid {
+		response.ExpiresAt = t.ExpiresAt.Time.String()
+	}
+	if t.LastUsedAt.Valid {
+		response.LastUsedAt = t.LastUsedAt.Time.String()
+	}
+	return response
+}
+
+// Mints a token for scripts; it is only shown in this response
+// expires_in_days is optional and the token never expires without it
+func (cfg *apiConfig) personalAccessTokenCreateHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	req := struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays *int     `json:"expires_in_days"`
+	}{}
+
+	err = chirpyDecodeJsonRequest(r, &req)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if len(name) == 0 || utf8.RuneCountInString(name) > maxPersonalAccessTokenNameLength {
+		chirpySendErrorResponse(w, 400, "Name must be 1-100 characters", nil)
+		return
+	}
+
+	scopes, err := auth.ParseScopes(req.Scopes)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid scopes", err)
+		return
+	}
+
+	expiresAt := sql.NullTime{}
+	if req.ExpiresInDays != nil {
+		days := *req.ExpiresInDays
+		if days < 1 || days > maxPersonalAccessTokenDays {
+			chirpySendErrorResponse(w, 400, "expires_in_days must be 1-366", nil)
+			return
+		}
+		expiresAt = sql.NullTime{Time: time.Now().AddDate(0, 0, days), Valid: true}
+	}
+
+	token, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to generate token", err)
+		return
+	}
+
+	dbToken, err := cfg.dbQueries.CreatePersonalAccessToken(r.Context(),
+		database.CreatePersonalAccessTokenParams{
+			UserID:    userID,
+			Name:      name,
+			TokenHash: auth.HashToken(token),
+			Scopes:    scopes,
+			ExpiresAt: expiresAt,
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to store token", err)
+		return
+	}
+
+	response := personalAccessTokenFromDatabase(dbToken)
+	response.Token = token
+
+	res, err := chirpyEncodeJsonResponse(201, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+func (cfg *apiConfig) personalAccessTokensGetHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	dbTokens, err := cfg.dbQueries.ListPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get tokens", err)
+		return
+	}
+
+	response := []personalAccessToken{}
+	for _, t := range dbTokens {
+		response = append(response, personalAccessTokenFromDatabase(t))
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+func (cfg *apiConfig) personalAccessTokenDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	tokenID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "Token not found", err)
+		return
+	}
+
+	rows, err := cfg.dbQueries.RevokePersonalAccessToken(r.Context(),
+		database.RevokePersonalAccessTokenParams{
+			ID:     tokenID,
+			UserID: userID,
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to revoke token", err)
+		return
+	}
+
+	if rows == 0 {
+		chirpySendErrorResponse(w, 404, "Token not found", nil)
+		return
+	}
+
+	w.WriteHeader(204)
+	w.Write([]byte{})
+}
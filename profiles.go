@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	maxAvatarURLLength   = 2048
+)
+
+var handlePattern = regexp.MustCompile(`^[a-z0-9_]{3,30}$`)
+
+// The public view of a user; never includes the email address
+type userProfile struct {
+	ID          string `json:"id"`
+	CreatedAt   string `json:"created_at"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url"`
+	IsChirpyRed bool   `json:"is_chirpy_red"`
+}
+
+func userProfileFromDatabase(u database.User) userProfile {
+	return userProfile{
+		ID:          u.ID.String(),
+		CreatedAt:   u.CreatedAt.String(),
+		Handle:      u.Handle,
+		DisplayName: u.DisplayName,
+		Bio:         u.Bio,
+		AvatarURL:   u.AvatarUrl,
+		IsChirpyRed: u.IsChirpyRed,
+	}
+}
+
+// Handles are matched case-insensitively and an @ prefix is optional
+func parseHandle(s string) (string, error) {
+	handle := strings.ToLower(strings.TrimPrefix(s, "@"))
+	if !handlePattern.MatchString(handle) {
+		return "", fmt.Errorf("Handle must be 3-30 letters, digits or underscores: %v", s)
+	}
+	return handle, nil
+}
+
+func validateDisplayName(s string) error {
+	if utf8.RuneCountInString(s) > maxDisplayNameLength {
+		return fmt.Errorf("Display name is longer than %v characters", maxDisplayNameLength)
+	}
+	return nil
+}
+
+func validateBio(s string) error {
+	if utf8.RuneCountInString(s) > maxBioLength {
+		return fmt.Errorf("Bio is longer than %v characters", maxBioLength)
+	}
+	return nil
+}
+
+// An empty URL clears the avatar
+func validateAvatarURL(s string) error {
+	if len(s) == 0 {
+		return nil
+	}
+
+	if len(s) > maxAvatarURLLength {
+		return fmt.Errorf("Avatar URL is longer than %v bytes", maxAvatarURLLength)
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("Invalid avatar URL: %w", err)
+	}
+
+	if (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 {
+		return fmt.Errorf("Avatar URL must be an http(s) URL: %v", s)
+	}
+
+	return nil
+}
+
+func (cfg *apiConfig) userGetHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "User not found", err)
+		return
+	}
+
+	dbUserRow, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "User not found", err)
+		return
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, userProfileFromDatabase(dbUserRow))
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+func (cfg *apiConfig) userGetByHandleHandler(w http.ResponseWriter, r *http.Request) {
+	handle, err := parseHandle(r.PathValue("handle"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "User not found", err)
+		return
+	}
+
+	dbUserRow, err := cfg.dbQueries.GetUserByHandle(r.Context(), handle)
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "User not found", err)
+		return
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, userProfileFromDatabase(dbUserRow))
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+)
+
+// Direct replies to a chirp, oldest first
+func (cfg *apiConfig) chirpRepliesGetHandler(w http.ResponseWriter, r *http.Request) {
+	parentID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "Chirp not found", err)
+		return
+	}
+
+	viewer, err := cfg.optionalViewer(r)
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	page, err := parsePageRequest(r)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid pagination parameters", err)
+		return
+	}
+
+	dbChirps := []database.Chirp{}
+
+	if page.backwards() {
+		dbChirps, err = cfg.dbQueries.ListRepliesBefore(r.Context(),
+			database.ListRepliesBeforeParams{
+				ParentID:        parentID,
+				ViewerID:        viewer,
+				CursorCreatedAt: page.cursorCreatedAt(),
+				CursorID:        page.cursorID(),
+				Limit:           page.fetchLimit(),
+			})
+	} else {
+		dbChirps, err = cfg.dbQueries.ListRepliesAfter(r.Context(),
+			database.ListRepliesAfterParams{
+				ParentID:        parentID,
+				ViewerID:        viewer,
+				CursorCreatedAt: page.cursorCreatedAt(),
+				CursorID:        page.cursorID(),
+				Limit:           page.fetchLimit(),
+			})
+	}
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get replies", err)
+		return
+	}
+
+	dbChirps, next, prev := paginate(dbChirps, page,
+		func(c database.Chirp) pageCursor {
+			return pageCursor{CreatedAt: c.CreatedAt, ID: c.ID}
+		})
+
+	chirps, err := cfg.chirpResponses(r.Context(), dbChirps, viewer)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get replies", err)
+		return
+	}
+
+	response := chirpPage{
+		Chirps:     chirps,
+		NextCursor: encodePageCursor(next),
+		PrevCursor: encodePageCursor(prev),
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	setPageLinks(w, r, next, prev)
+	chirpySendResponse(w, res)
+}
+
+type chirpThreadNode struct {
+	chirp
+	Depth   int32              `json:"depth"`
+	Replies []*chirpThreadNode `json:"replies"`
+}
+
+// The whole conversation a chirp belongs to, as a tree rooted at the
+// original chirp. Deleted chirps appear as tombstones so their replies
+// stay in place
+func (cfg *apiConfig) chirpThreadGetHandler(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "Chirp not found", err)
+		return
+	}
+
+	viewer, err := cfg.optionalViewer(r)
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	ancestors, err := cfg.dbQueries.GetChirpAncestors(r.Context(), chirpID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get thread", err)
+		return
+	}
+
+	if len(ancestors) == 0 {
+		chirpySendErrorResponse(w, 404, "Chirp not found", nil)
+		return
+	}
+
+	// The topmost chirp may still point at a parent that was deleted
+	// together with its author; that parent becomes the root tombstone
+	top := ancestors[len(ancestors)-1]
+	rootID := top.ID
+	if top.InReplyTo.Valid {
+		rootID = top.InReplyTo.UUID
+	}
+
+	rows, err := cfg.dbQueries.GetChirpThread(r.Context(), rootID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get thread", err)
+		return
+	}
+
+	root := &chirpThreadNode{
+		chirp:   chirpTombstone(rootID, uuid.NullUUID{}),
+		Replies: []*chirpThreadNode{},
+	}
+	nodes := map[uuid.UUID]*chirpThreadNode{rootID: root}
+
+	dbChirps := []database.Chirp{}
+	for _, row := range rows {
+		dbChirps = append(dbChirps, database.Chirp{
+			ID:        row.ID,
+			CreatedAt: row.CreatedAt,
+			UpdatedAt: row.UpdatedAt,
+			Body:      row.Body,
+			UserID:    row.UserID,
+			InReplyTo: row.InReplyTo,
+			DeletedAt: row.DeletedAt,
+			RechirpOf: row.RechirpOf,
+			HiddenAt:  row.HiddenAt,
+		})
+	}
+
+	chirps, err := cfg.chirpResponses(r.Context(), dbChirps, viewer)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get thread", err)
+		return
+	}
+
+	// Rows are ordered by depth so parents are always seen first
+	for i, row := range rows {
+		c := chirps[i]
+
+		if row.ID == rootID {
+			root.chirp = c
+			continue
+		}
+
+		parent, ok := nodes[row.InReplyTo.UUID]
+		if !ok {
+			log.Printf("Error: thread %v: orphaned reply %v", rootID, row.ID)
+			continue
+		}
+
+		node := &chirpThreadNode{
+			chirp:   c,
+			Depth:   row.Depth,
+			Replies: []*chirpThreadNode{},
+		}
+		parent.Replies = append(parent.Replies, node)
+		nodes[row.ID] = node
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, root)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/auth"
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+)
+
+const maxReportReasonLength = 500
+
+type report struct {
+	ID         string `json:"id"`
+	CreatedAt  string `json:"created_at"`
+	UpdatedAt  string `json:"updated_at"`
+	ChirpID    string `json:"chirp_id"`
+	ReporterID string `json:"reporter_id,omitempty"`
+	Reason     string `json:"reason"`
+	Status     string `json:"status"`
+	ResolvedAt string `json:"resolved_at,omitempty"`
+}
+
+func reportFromDatabase(r database.Report) report {
+	response := report{
+		ID:        r.ID.String(),
+		CreatedAt: r.CreatedAt.String(),
+		UpdatedAt: r.UpdatedAt.String(),
+		ChirpID:   r.ChirpID.String(),
+		Reason:    r.Reason,
+		Status:    r.Status,
+	}
+	if r.ReporterID.Valid {
+		response.ReporterID = r.ReporterID.UUID.String()
+	}
+	if r.ResolvedAt.Valid {
+		response.ResolvedAt = r.ResolvedAt.Time.String()
+	}
+	return response
+}
+
+// Queues a chirp for review by a moderator
+func (cfg *apiConfig) chirpReportHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, auth.ScopeChirpsWrite)
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "Chirp not found", err)
+		return
+	}
+
+	req := struct {
+		Reason string `json:"reason"`
+	}{}
+
+	err = chirpyDecodeJsonRequest(r, &req)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		return
+	}
+
+	reason := strings.TrimSpace(req.Reason)
+	if len(reason) == 0 {
+		chirpySendErrorResponse(w, 400, "Reason required", nil)
+		return
+	}
+
+	if len(reason) > maxReportReasonLength {
+		chirpySendErrorResponse(w, 400, "Reason is too long", nil)
+		return
+	}
+
+	viewer := uuid.NullUUID{UUID: userID, Valid: true}
+
+	dbChirpRow, err := cfg.dbQueries.GetChirpByID(r.Context(), chirpID)
+	if err != nil || dbChirpRow.DeletedAt.Valid || chirpHiddenFrom(dbChirpRow, viewer) {
+		chirpySendErrorResponse(w, 404, "Chirp not found", err)
+		return
+	}
+
+	if dbChirpRow.UserID == userID {
+		chirpySendErrorResponse(w, 400, "Cannot report your own chirp", nil)
+		return
+	}
+
+	dbReport, err := cfg.dbQueries.CreateReport(r.Context(),
+		database.CreateReportParams{
+			ChirpID:    chirpID,
+			ReporterID: viewer,
+			Reason:     reason,
+		})
+	if err != nil {
+		e, ok := err.(*pq.Error)
+		if ok &&
+			e.Code.Name() == "unique_violation" &&
+			e.Constraint == "reports_open_reporter_key" {
+
+			chirpySendErrorResponse(w, 400, "Already reported", e)
+			return
+		}
+		chirpySendErrorResponse(w, 500, "Failed to report chirp", err)
+		return
+	}
+
+	res, err := chirpyEncodeJsonResponse(201, reportFromDatabase(dbReport))
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
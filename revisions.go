@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/auth"
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+)
+
+// Replaces the body of one of the caller's chirps, keeping the old body as
+// a revision. Editing is a Chirpy Red feature
+func (cfg *apiConfig) chirpEditHandler(w http.ResponseWriter, r *http.Request) {
+	c := chirp{}
+
+	userID, err := cfg.authenticate(r, auth.ScopeChirpsWrite)
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "Chirp not found", err)
+		return
+	}
+
+	err = chirpyDecodeJsonRequest(r, &c)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		return
+	}
+
+	dbChirpRow, err := cfg.dbQueries.GetChirpByID(r.Context(), chirpID)
+	if err != nil || dbChirpRow.DeletedAt.Valid {
+		chirpySendErrorResponse(w, 404, "Chirp not found", err)
+		return
+	}
+
+	if dbChirpRow.UserID != userID {
+		chirpySendErrorResponse(w, 403, "Unauthorized", err)
+		return
+	}
+
+	dbUserRow, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 401, "Authorization failed", err)
+		return
+	}
+
+	if !dbUserRow.IsChirpyRed {
+		chirpySendErrorResponse(w, 403, "Editing chirps requires Chirpy Red", nil)
+		return
+	}
+
+	// A rechirp's empty body is what makes it plain rather than a quote
+	if dbChirpRow.RechirpOf.Valid && len(dbChirpRow.Body) == 0 {
+		chirpySendErrorResponse(w, 400, "Plain rechirps cannot be edited", nil)
+		return
+	}
+
+	moderated, err := cfg.moderateChirpBody(c.Body)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Chirp is too long", err)
+		return
+	}
+
+	if moderated.Rejected {
+		chirpySendErrorResponse(w, 400, "Chirp violates content rules",
+			fmt.Errorf("Matched: %v", moderated.Matches))
+		return
+	}
+
+	cleanedBody := moderated.Body
+
+	if dbChirpRow.RechirpOf.Valid && len(cleanedBody) == 0 {
+		chirpySendErrorResponse(w, 400, "Quote chirps need a body", nil)
+		return
+	}
+
+	dbStatus, err := cfg.dbQueries.EditChirp(r.Context(),
+		database.EditChirpParams{
+			ID:     chirpID,
+			UserID: userID,
+			Body:   cleanedBody,
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to edit chirp", err)
+		return
+	}
+
+	if moderated.Flagged {
+		cfg.flagChirp(r.Context(), dbStatus.ID, moderated)
+	}
+
+	chirps, err := cfg.chirpResponses(r.Context(),
+		[]database.Chirp{dbStatus}, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to edit chirp", err)
+		return
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, chirps[0])
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+type chirpRevision struct {
+	Body       string `json:"body"`
+	CreatedAt  string `json:"created_at"`
+	ReplacedAt string `json:"replaced_at"`
+}
+
+// Earlier bodies of a chirp, most recently replaced first
+func (cfg *apiConfig) chirpRevisionsGetHandler(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "Chirp not found", err)
+		return
+	}
+
+	viewer, err := cfg.optionalViewer(r)
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	dbChirpRow, err := cfg.dbQueries.GetChirpByID(r.Context(), chirpID)
+	if err != nil || dbChirpRow.DeletedAt.Valid || chirpHiddenFrom(dbChirpRow, viewer) {
+		chirpySendErrorResponse(w, 404, "Chirp not found", err)
+		return
+	}
+
+	dbRevisions, err := cfg.dbQueries.GetChirpRevisions(r.Context(), chirpID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get revisions", err)
+		return
+	}
+
+	type revisionList struct {
+		Revisions []chirpRevision `json:"revisions"`
+	}
+
+	response := revisionList{
+		Revisions: []chirpRevision{},
+	}
+
+	for _, revision := range dbRevisions {
+		response.Revisions = append(response.Revisions, chirpRevision{
+			Body:       revision.Body,
+			CreatedAt:  revision.CreatedAt.String(),
+			ReplacedAt: revision.ReplacedAt.String(),
+		})
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/google/uuid"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/auth"
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+)
+
+// Only allows requests made with a login token carrying role or a more
+// powerful one; personal access tokens and tokens issued to other apps never
+// carry a role
+func (cfg *apiConfig) requireRole(role string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			chirpySendErrorResponse(w, 401, "Authorization failed", err)
+			return
+		}
+
+		if auth.IsPersonalAccessToken(token) {
+			sendAuthenticationError(w, errLoginRequired)
+			return
+		}
+
+		accessToken, err := cfg.validateAccessToken(r.Context(), token)
+		if err != nil {
+			chirpySendErrorResponse(w, 401, "Authorization failed", err)
+			return
+		}
+
+		if !auth.HasRole(accessToken.Role, role) {
+			chirpySendErrorResponse(w, 403, fmt.Sprintf("Requires the %v role", role), nil)
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+// Makes the user with BOOTSTRAP_ADMIN_EMAIL an admin at startup, so the
+// first admin can be appointed before there is anyone to do it
+// Does nothing once there is an admin, and the address must be verified
+func (cfg *apiConfig) bootstrapAdmin(ctx context.Context) {
+	email := os.Getenv("BOOTSTRAP_ADMIN_EMAIL")
+	if email == "" {
+		return
+	}
+
+	hasAdmin, err := cfg.dbQueries.HasAdmin(ctx)
+	if err != nil {
+		fmt.Printf("Warning: failed to check for an admin: %v\n", err)
+		return
+	}
+	if hasAdmin {
+		return
+	}
+
+	rows, err := cfg.dbQueries.BootstrapAdmin(ctx, email)
+	if err != nil {
+		fmt.Printf("Warning: failed to make %v an admin: %v\n", email, err)
+		return
+	}
+
+	if rows == 0 {
+		fmt.Printf("Warning: no user with the verified email %v to make an admin\n", email)
+		return
+	}
+
+	fmt.Printf("Made %v an admin\n", email)
+}
+
+type staffMember struct {
+	ID     string `json:"id"`
+	Email  string `json:"email"`
+	Handle string `json:"handle"`
+	Role   string `json:"role"`
+}
+
+func staffMemberFromDatabase(u database.User) staffMember {
+	return staffMember{
+		ID:     u.ID.String(),
+		Email:  u.Email,
+		Handle: u.Handle,
+		Role:   u.Role,
+	}
+}
+
+// Lists moderators and admins
+func (cfg *apiConfig) staffGetHandler(w http.ResponseWriter, r *http.Request) {
+	dbUsers, err := cfg.dbQueries.ListStaff(r.Context())
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get staff", err)
+		return
+	}
+
+	response := []staffMember{}
+	for _, u := range dbUsers {
+		response = append(response, staffMemberFromDatabase(u))
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+// Changes a user's role
+// Their access tokens stop working so the old role can't be used; they get
+// tokens with the new one when they refresh
+func (cfg *apiConfig) userRoleSetHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "User not found", err)
+		return
+	}
+
+	req := struct {
+		Role string `json:"role"`
+	}{}
+
+	err = chirpyDecodeJsonRequest(r, &req)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid request", err)
+		return
+	}
+
+	role, err := auth.ParseRole(req.Role)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid role", err)
+		return
+	}
+
+	// Keeps there from ever being no admin left
+	if userID == adminID {
+		chirpySendErrorResponse(w, 400, "Admins can't change their own role", nil)
+		return
+	}
+
+	dbUserRow, err := cfg.dbQueries.SetUserRole(r.Context(),
+		database.SetUserRoleParams{
+			ID:   userID,
+			Role: role,
+		})
+	if errors.Is(err, sql.ErrNoRows) {
+		chirpySendErrorResponse(w, 404, "User not found", err)
+		return
+	}
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to set role", err)
+		return
+	}
+
+	err = cfg.dbQueries.RevokeUserAccessTokens(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to revoke access tokens", err)
+		return
+	}
+
+	log.Printf("Admin %v set the role of user %v to %v", adminID, userID, role)
+
+	res, err := chirpyEncodeJsonResponse(200, staffMemberFromDatabase(dbUserRow))
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+)
+
+// Converts a user supplied search string into a to_tsquery expression
+// Every term must match. "Quoted words" match as a phrase and a trailing *
+// matches by prefix, e.g. `"red bird" chirp*` becomes `(red <-> bird) & chirp:*`
+// Anything that isn't a letter or digit is dropped so the result is always
+// valid tsquery syntax
+func buildSearchQuery(q string) (string, error) {
+	terms := []string{}
+
+	segments := strings.Split(q, `"`)
+	for i, segment := range segments {
+		// Odd segments are inside quotes
+		if i%2 == 1 {
+			words := searchLexemes(segment)
+			if len(words) > 0 {
+				terms = append(terms, searchPhrase(words))
+			}
+			continue
+		}
+
+		for _, word := range strings.Fields(segment) {
+			prefix := strings.HasSuffix(word, "*")
+			words := searchLexemes(word)
+			if len(words) == 0 {
+				continue
+			}
+			if prefix {
+				words[len(words)-1] += ":*"
+			}
+			terms = append(terms, searchPhrase(words))
+		}
+	}
+
+	if len(terms) == 0 {
+		return "", fmt.Errorf("No searchable terms in query: %v", q)
+	}
+
+	return strings.Join(terms, " & "), nil
+}
+
+func searchLexemes(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func searchPhrase(words []string) string {
+	if len(words) == 1 {
+		return words[0]
+	}
+	return "(" + strings.Join(words, " <-> ") + ")"
+}
+
+func (cfg *apiConfig) chirpsSearchHandler(w http.ResponseWriter, r *http.Request) {
+	query, err := buildSearchQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid search query", err)
+		return
+	}
+
+	viewer, err := cfg.optionalViewer(r)
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	page, err := parsePageRequest(r)
+	if err != nil {
+		chirpySendErrorResponse(w, 400, "Invalid pagination parameters", err)
+		return
+	}
+
+	author := uuid.NullUUID{}
+
+	authorID := r.URL.Query().Get("author_id")
+	if len(authorID) > 0 {
+		authorID, err := uuid.Parse(authorID)
+		if err != nil {
+			chirpySendErrorResponse(w, 400, "Invalid author id", err)
+			return
+		}
+		author = uuid.NullUUID{UUID: authorID, Valid: true}
+	}
+
+	results := []database.SearchChirpsRow{}
+
+	if page.backwards() {
+		rows, err := cfg.dbQueries.SearchChirpsReverse(r.Context(),
+			database.SearchChirpsReverseParams{
+				Query:           query,
+				AuthorID:        author,
+				ViewerID:        viewer,
+				CursorRank:      page.cursorRank(),
+				CursorCreatedAt: page.cursorCreatedAt(),
+				CursorID:        page.cursorID(),
+				Limit:           page.fetchLimit(),
+			})
+		if err != nil {
+			chirpySendErrorResponse(w, 500, "Failed to search chirps", err)
+			return
+		}
+		for _, row := range rows {
+			results = append(results, database.SearchChirpsRow(row))
+		}
+	} else {
+		results, err = cfg.dbQueries.SearchChirps(r.Context(),
+			database.SearchChirpsParams{
+				Query:           query,
+				AuthorID:        author,
+				ViewerID:        viewer,
+				CursorRank:      page.cursorRank(),
+				CursorCreatedAt: page.cursorCreatedAt(),
+				CursorID:        page.cursorID(),
+				Limit:           page.fetchLimit(),
+			})
+		if err != nil {
+			chirpySendErrorResponse(w, 500, "Failed to search chirps", err)
+			return
+		}
+	}
+
+	results, next, prev := paginate(results, page,
+		func(c database.SearchChirpsRow) pageCursor {
+			return pageCursor{Rank: c.Rank, CreatedAt: c.CreatedAt, ID: c.ID}
+		})
+
+	dbChirps := []database.Chirp{}
+	for _, c := range results {
+		dbChirps = append(dbChirps, database.Chirp{
+			ID:        c.ID,
+			CreatedAt: c.CreatedAt,
+			UpdatedAt: c.UpdatedAt,
+			Body:      c.Body,
+			UserID:    c.UserID,
+			InReplyTo: c.InReplyTo,
+			RechirpOf: c.RechirpOf,
+			HiddenAt:  c.HiddenAt,
+		})
+	}
+
+	chirps, err := cfg.chirpResponses(r.Context(), dbChirps, viewer)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to search chirps", err)
+		return
+	}
+
+	response := chirpPage{
+		Chirps:     chirps,
+		NextCursor: encodePageCursor(next),
+		PrevCursor: encodePageCursor(prev),
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	setPageLinks(w, r, next, prev)
+	chirpySendResponse(w, res)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -75,3 +76,21 @@ func chirpyDecodeJsonRequest(r *http.Request, t any) error {
 	}
 	return nil
 }
+
+// For requests whose fields are all optional; an empty body leaves t as it is
+func chirpyDecodeOptionalJsonRequest(r *http.Request, t any) error {
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	if len(bytes.TrimSpace(content)) == 0 {
+		return nil
+	}
+
+	err = json.Unmarshal(content, t)
+	if err != nil {
+		return fmt.Errorf("Failed to decode json: %w", err)
+	}
+	return nil
+}
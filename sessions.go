@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/Tavis7/bootdev-chirpy/internal/database"
+)
+
+const maxUserAgentLength = 512
+
+// The address of the connecting client; proxies in front of the server are
+// not trusted to report the original address
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func clientUserAgent(r *http.Request) string {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return userAgent
+}
+
+type session struct {
+	ID         string `json:"id"`
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at"`
+	ExpiresAt  string `json:"expires_at"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+}
+
+// Lists the caller's logins that can still be refreshed, most recently used
+// first
+func (cfg *apiConfig) sessionsGetHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	dbSessions, err := cfg.dbQueries.ListSessions(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to get sessions", err)
+		return
+	}
+
+	type sessionList struct {
+		Sessions []session `json:"sessions"`
+	}
+
+	response := sessionList{
+		Sessions: []session{},
+	}
+
+	for _, s := range dbSessions {
+		response.Sessions = append(response.Sessions, session{
+			ID:         s.FamilyID.String(),
+			CreatedAt:  s.CreatedAt.String(),
+			LastUsedAt: s.LastUsedAt.String(),
+			ExpiresAt:  s.ExpiresAt.String(),
+			UserAgent:  s.UserAgent,
+			IP:         s.Ip,
+		})
+	}
+
+	res, err := chirpyEncodeJsonResponse(200, response)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		// continue
+	}
+
+	chirpySendResponse(w, res)
+}
+
+func (cfg *apiConfig) sessionDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	familyID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		chirpySendErrorResponse(w, 404, "Session not found", err)
+		return
+	}
+
+	revoked, err := cfg.dbQueries.RevokeSession(r.Context(),
+		database.RevokeSessionParams{
+			FamilyID: familyID,
+			UserID:   userID,
+		})
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to revoke session", err)
+		return
+	}
+
+	if revoked == 0 {
+		chirpySendErrorResponse(w, 404, "Session not found", nil)
+		return
+	}
+
+	w.WriteHeader(204)
+	w.Write([]byte{})
+}
+
+// Logs the caller out everywhere, including access tokens already issued and
+// personal access tokens
+func (cfg *apiConfig) sessionsRevokeAllHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, "")
+	if err != nil {
+		sendAuthenticationError(w, err)
+		return
+	}
+
+	err = cfg.revokeUserTokens(r.Context(), userID)
+	if err != nil {
+		chirpySendErrorResponse(w, 500, "Failed to revoke sessions", err)
+		return
+	}
+
+	w.WriteHeader(204)
+	w.Write([]byte{})
+}
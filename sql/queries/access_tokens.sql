@@ -0,0 +1,33 @@
+-- name: RevokeAccessToken :exec
+INSERT INTO revoked_access_tokens (jti, created_at, user_id, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3
+)
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteExpiredRevokedAccessTokens :execrows
+DELETE FROM revoked_access_tokens
+WHERE expires_at < NOW();
+
+-- name: RevokeUserAccessTokens :exec
+-- iat only has second precision, so tokens issued later in the same second
+-- must not count as older
+UPDATE users
+SET updated_at = NOW(), tokens_valid_after = date_trunc('second', NOW())
+WHERE id = $1;
+
+-- name: IsAccessTokenRevoked :one
+-- Tokens of deleted users are revoked too
+SELECT (
+    EXISTS (
+        SELECT 1 FROM revoked_access_tokens WHERE jti = sqlc.arg('jti')
+    ) OR NOT EXISTS (
+        SELECT 1 FROM users
+        WHERE id = sqlc.arg('user_id')
+        AND (tokens_valid_after IS NULL
+            OR tokens_valid_after <= sqlc.arg('issued_at')::timestamp)
+    )
+)::boolean AS revoked;
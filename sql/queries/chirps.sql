@@ -1,29 +1,116 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to,
+    rechirp_of)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3,
+    $4
 )
 RETURNING *;
 
--- name: GetAllChirps :many
-SELECT * FROM chirps ORDER BY created_at ASC;
+-- name: ListChirpsAfter :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+AND (hidden_at IS NULL OR user_id = sqlc.narg('viewer_id'))
+AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) > (sqlc.narg('cursor_created_at'), sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('limit');
 
--- name: GetChirpsByAuthorID :many
-SELECT * FROM chirps WHERE user_id = $1 ORDER BY created_at ASC;
+-- name: ListChirpsBefore :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+AND (hidden_at IS NULL OR user_id = sqlc.narg('viewer_id'))
+AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at'), sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
 
 -- name: GetChirpByID :one
 SELECT * FROM chirps WHERE id = $1;
 
+-- name: GetChirpsByIDs :many
+SELECT * FROM chirps WHERE id = ANY(sqlc.arg('ids')::uuid[]);
+
 -- name: DeleteChirpByID :one
 DELETE FROM chirps *
 WHERE id = $1 AND user_id = $2
 RETURNING *;
 
+-- name: MarkChirpDeleted :one
+UPDATE chirps
+SET updated_at = NOW(), deleted_at = NOW(), body = ''
+WHERE id = $1 AND user_id = $2
+RETURNING *;
+
 -- name: ResetChirps :many
 DELETE FROM chirps *
 RETURNING *;
 
+
+-- name: SearchChirps :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, rechirp_of,
+    hidden_at, rank
+FROM (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, rechirp_of,
+        hidden_at, ts_rank(search_vector, to_tsquery('english', sqlc.arg('query')))::real AS rank
+    FROM chirps
+    WHERE deleted_at IS NULL
+    AND search_vector @@ to_tsquery('english', sqlc.arg('query'))
+    AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+    AND (hidden_at IS NULL OR user_id = sqlc.narg('viewer_id'))
+) AS results
+WHERE (sqlc.narg('cursor_rank')::real IS NULL
+    OR (rank, created_at, id) < (sqlc.narg('cursor_rank'),
+        sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY rank DESC, created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: SearchChirpsReverse :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, rechirp_of,
+    hidden_at, rank
+FROM (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, rechirp_of,
+        hidden_at, ts_rank(search_vector, to_tsquery('english', sqlc.arg('query')))::real AS rank
+    FROM chirps
+    WHERE deleted_at IS NULL
+    AND search_vector @@ to_tsquery('english', sqlc.arg('query'))
+    AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+    AND (hidden_at IS NULL OR user_id = sqlc.narg('viewer_id'))
+) AS results
+WHERE (sqlc.narg('cursor_rank')::real IS NULL
+    OR (rank, created_at, id) > (sqlc.narg('cursor_rank'),
+        sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY rank ASC, created_at ASC, id ASC
+LIMIT sqlc.arg('limit');
+
+-- name: HideChirp :one
+UPDATE chirps
+SET hidden_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING *;
+
+-- name: UnhideChirp :one
+UPDATE chirps
+SET hidden_at = NULL
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING *;
+
+-- name: RemoveChirp :one
+UPDATE chirps
+SET updated_at = NOW(), deleted_at = NOW(), body = ''
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING *;
+
+-- name: ListUserChirps :many
+-- Every chirp a user has made, including hidden ones and tombstones
+SELECT *
+FROM chirps
+WHERE user_id = $1
+ORDER BY created_at, id;
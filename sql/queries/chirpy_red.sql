@@ -3,3 +3,18 @@ UPDATE users
 SET updated_at = NOW(), is_chirpy_red = true
 WHERE id = $1
 RETURNING *;
+
+-- name: RecordSubscriptionEvent :exec
+INSERT INTO subscription_events (id, created_at, user_id, event)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2
+);
+
+-- name: ListSubscriptionEvents :many
+SELECT *
+FROM subscription_events
+WHERE user_id = $1
+ORDER BY created_at, id;
@@ -0,0 +1,28 @@
+-- name: CreateEmailToken :exec
+INSERT INTO email_tokens (token_hash, created_at, user_id, purpose, email, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5
+);
+
+-- name: UseEmailToken :one
+-- Claims a live token; fails if it was already used or has expired
+UPDATE email_tokens
+SET used_at = NOW()
+WHERE token_hash = $1 AND purpose = $2
+    AND used_at IS NULL AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteEmailTokens :exec
+-- Invalidates every outstanding token of one kind for a user
+DELETE FROM email_tokens
+WHERE user_id = $1 AND purpose = $2;
+
+-- name: VerifyUserEmail :execrows
+UPDATE users
+SET updated_at = NOW(), email_verified_at = NOW()
+WHERE id = $1 AND email = $2 AND email_verified_at IS NULL;
@@ -0,0 +1,65 @@
+-- name: GetLoginFailure :one
+SELECT *
+FROM login_failures
+WHERE kind = $1 AND key = $2;
+
+-- name: ReserveLoginAttempt :one
+-- Lets an attempt through before the password or code is checked, returning
+-- no rows while locked. Past the free attempts only one attempt may be in
+-- flight at a time. Attempts let through over a minute ago are from requests
+-- that never finished and no longer count
+INSERT INTO login_failures (kind, key, failures, last_failed_at, pending, reserved_at)
+VALUES (sqlc.arg('kind'), sqlc.arg('key'), 0, NOW(), 1, NOW())
+ON CONFLICT (kind, key) DO UPDATE
+SET pending = CASE
+        WHEN login_failures.reserved_at < NOW() - INTERVAL '1 minute' THEN 1
+        ELSE login_failures.pending + 1
+    END,
+    reserved_at = NOW()
+WHERE (login_failures.locked_until IS NULL OR login_failures.locked_until <= NOW())
+    AND (login_failures.pending = 0
+        OR login_failures.reserved_at < NOW() - INTERVAL '1 minute'
+        OR CASE
+            WHEN login_failures.last_failed_at < NOW() - INTERVAL '1 day' THEN 0
+            ELSE login_failures.failures
+        END + login_failures.pending < sqlc.arg('free_attempts'))
+RETURNING *;
+
+-- name: ReleaseLoginAttempt :exec
+UPDATE login_failures
+SET pending = GREATEST(pending - 1, 0)
+WHERE kind = $1 AND key = $2;
+
+-- name: RecordLoginFailure :one
+-- The count starts over once a day has passed without a failure
+INSERT INTO login_failures (kind, key, failures, last_failed_at)
+VALUES ($1, $2, 1, NOW())
+ON CONFLICT (kind, key) DO UPDATE
+SET failures = CASE
+        WHEN login_failures.last_failed_at < NOW() - INTERVAL '1 day' THEN 1
+        ELSE login_failures.failures + 1
+    END,
+    last_failed_at = NOW(),
+    pending = GREATEST(login_failures.pending - 1, 0)
+RETURNING *;
+
+-- name: LockLogin :exec
+UPDATE login_failures
+SET locked_until = $3
+WHERE kind = $1 AND key = $2;
+
+-- name: ClearLoginFailures :execrows
+DELETE FROM login_failures
+WHERE kind = $1 AND key = $2;
+
+-- name: ListLoginLockouts :many
+SELECT *
+FROM login_failures
+WHERE locked_until > NOW()
+ORDER BY locked_until DESC;
+
+-- name: DeleteStaleLoginFailures :execrows
+DELETE FROM login_failures
+WHERE last_failed_at < NOW() - INTERVAL '1 day'
+    AND (locked_until IS NULL OR locked_until < NOW())
+    AND (reserved_at IS NULL OR reserved_at < NOW() - INTERVAL '1 minute');
@@ -0,0 +1,19 @@
+-- name: GetModerationRules :many
+SELECT * FROM moderation_rules
+ORDER BY word ASC;
+
+-- name: SetModerationRule :one
+INSERT INTO moderation_rules (word, created_at, updated_at, action)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2
+)
+ON CONFLICT (word) DO UPDATE
+SET updated_at = NOW(), action = EXCLUDED.action
+RETURNING *;
+
+-- name: DeleteModerationRule :execrows
+DELETE FROM moderation_rules
+WHERE word = $1;
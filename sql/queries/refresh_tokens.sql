@@ -1,13 +1,18 @@
 -- name: StoreRefreshToken :one
 INSERT INTO refresh_tokens(token, created_at, updated_at,
-    user_id, expires_at, revoked_at)
+    user_id, expires_at, revoked_at, family_id, user_agent, ip, client_id, scopes)
 VALUES (
     $1,
     NOW(),
     NOW(),
     $2,
     $3,
-    NULL
+    NULL,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8
 )
 RETURNING *;
 
@@ -21,3 +26,56 @@ UPDATE refresh_tokens
 SET updated_at = NOW(), revoked_at = NOW()
 WHERE token = $1
 RETURNING *;
+
+-- name: RotateRefreshToken :one
+-- Claims a live token for rotation; returns no rows if the token was
+-- already used, revoked or has expired, or was issued to another client
+-- client_id is NULL for tokens from logging in
+UPDATE refresh_tokens
+SET updated_at = NOW(), revoked_at = NOW(), replaced_by = sqlc.arg('replaced_by')
+WHERE token = sqlc.arg('token') AND revoked_at IS NULL AND expires_at > NOW()
+    AND client_id IS NOT DISTINCT FROM sqlc.narg('client_id')
+RETURNING *;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET updated_at = NOW(), revoked_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL;
+
+-- name: ListSessions :many
+-- A session is a token family; only its newest token is still live, so that
+-- token's creation is when the session was last used
+SELECT live.family_id, started.created_at, live.created_at AS last_used_at,
+    live.expires_at, live.user_agent, live.ip
+FROM refresh_tokens AS live
+JOIN LATERAL (
+    SELECT MIN(created_at)::timestamp AS created_at
+    FROM refresh_tokens
+    WHERE refresh_tokens.family_id = live.family_id
+) AS started ON true
+WHERE live.user_id = $1 AND live.revoked_at IS NULL AND live.expires_at > NOW()
+ORDER BY live.created_at DESC;
+
+-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+SET updated_at = NOW(), revoked_at = NOW()
+WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeAllSessions :exec
+UPDATE refresh_tokens
+SET updated_at = NOW(), revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: ListSessionHistory :many
+-- Every session a user has had, including ones that ended; the token that
+-- wasn't replaced is the newest in its family
+SELECT latest.family_id, started.created_at, latest.created_at AS last_used_at,
+    latest.expires_at, latest.revoked_at, latest.user_agent, latest.ip
+FROM refresh_tokens AS latest
+JOIN LATERAL (
+    SELECT MIN(created_at)::timestamp AS created_at
+    FROM refresh_tokens
+    WHERE refresh_tokens.family_id = latest.family_id
+) AS started ON true
+WHERE latest.user_id = $1 AND latest.replaced_by IS NULL
+ORDER BY started.created_at;
@@ -0,0 +1,54 @@
+-- name: HasReplies :one
+SELECT EXISTS (
+    SELECT 1 FROM chirps WHERE in_reply_to = sqlc.arg('parent_id')::uuid
+);
+
+-- name: ListRepliesAfter :many
+SELECT * FROM chirps
+WHERE in_reply_to = sqlc.arg('parent_id')::uuid
+AND (hidden_at IS NULL OR user_id = sqlc.narg('viewer_id'))
+AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) > (sqlc.narg('cursor_created_at'), sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('limit');
+
+-- name: ListRepliesBefore :many
+SELECT * FROM chirps
+WHERE in_reply_to = sqlc.arg('parent_id')::uuid
+AND (hidden_at IS NULL OR user_id = sqlc.narg('viewer_id'))
+AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at'), sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT id, in_reply_to, 0 AS distance
+    FROM chirps
+    WHERE id = $1
+    UNION ALL
+    SELECT chirps.id, chirps.in_reply_to, ancestors.distance + 1
+    FROM chirps
+    JOIN ancestors ON chirps.id = ancestors.in_reply_to
+)
+SELECT id, in_reply_to FROM ancestors
+ORDER BY distance ASC;
+
+-- name: GetChirpThread :many
+WITH RECURSIVE thread AS (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at,
+        rechirp_of, hidden_at, CASE WHEN id = sqlc.arg('root_id') THEN 0 ELSE 1 END AS depth
+    FROM chirps
+    WHERE id = sqlc.arg('root_id') OR in_reply_to = sqlc.arg('root_id')
+    UNION ALL
+    SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body,
+        chirps.user_id, chirps.in_reply_to, chirps.deleted_at, chirps.rechirp_of,
+        chirps.hidden_at, thread.depth + 1
+    FROM chirps
+    JOIN thread ON chirps.in_reply_to = thread.id
+    WHERE thread.id <> sqlc.arg('root_id')
+)
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at,
+    rechirp_of, hidden_at, depth
+FROM thread
+ORDER BY depth ASC, created_at ASC, id ASC;
@@ -0,0 +1,60 @@
+-- name: CreateReport :one
+INSERT INTO reports (id, created_at, updated_at, chirp_id, reporter_id, reason)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3
+)
+RETURNING *;
+
+-- name: GetReportByID :one
+SELECT * FROM reports WHERE id = $1;
+
+-- name: ListReportsAfter :many
+SELECT * FROM reports
+WHERE status = sqlc.arg('status')
+AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) > (sqlc.narg('cursor_created_at'), sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('limit');
+
+-- name: ListReportsBefore :many
+SELECT * FROM reports
+WHERE status = sqlc.arg('status')
+AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at'), sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: ResolveReport :one
+UPDATE reports
+SET updated_at = NOW(), resolved_at = NOW(), status = $2
+WHERE id = $1 AND status = 'open'
+RETURNING *;
+
+-- name: ResolveChirpReports :many
+UPDATE reports
+SET updated_at = NOW(), resolved_at = NOW(), status = 'resolved'
+WHERE chirp_id = $1 AND status = 'open'
+RETURNING *;
+
+-- name: LogModerationAction :one
+INSERT INTO moderation_actions (id, created_at, chirp_id, report_id, action, note, moderator_id)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+)
+RETURNING *;
+
+-- name: GetModerationActionsByChirpID :many
+SELECT * FROM moderation_actions
+WHERE chirp_id = $1
+ORDER BY created_at ASC, id ASC;
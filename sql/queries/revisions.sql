@@ -0,0 +1,18 @@
+-- name: EditChirp :one
+WITH revision AS (
+    INSERT INTO chirp_revisions (id, chirp_id, created_at, replaced_at, body)
+    SELECT gen_random_uuid(), id, updated_at, NOW(), body
+    FROM chirps
+    WHERE id = sqlc.arg('id') AND user_id = sqlc.arg('user_id')
+    AND deleted_at IS NULL
+)
+UPDATE chirps
+SET updated_at = NOW(), body = sqlc.arg('body')
+WHERE id = sqlc.arg('id') AND user_id = sqlc.arg('user_id')
+AND deleted_at IS NULL
+RETURNING *;
+
+-- name: GetChirpRevisions :many
+SELECT * FROM chirp_revisions
+WHERE chirp_id = $1
+ORDER BY replaced_at DESC;
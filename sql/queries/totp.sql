@@ -0,0 +1,42 @@
+-- name: SetTOTPSecret :execrows
+-- Starts enrollment over with a new secret unless 2FA is already enabled
+UPDATE users
+SET updated_at = NOW(), totp_secret = $2, totp_last_step = 0
+WHERE id = $1 AND totp_enabled_at IS NULL;
+
+-- name: EnableTOTP :execrows
+-- Only with the secret the code was checked against, in case enrollment was
+-- started over in the meantime
+UPDATE users
+SET updated_at = NOW(), totp_enabled_at = NOW(), totp_last_step = $2
+WHERE id = $1 AND totp_secret = $3 AND totp_enabled_at IS NULL;
+
+-- name: DisableTOTP :exec
+UPDATE users
+SET updated_at = NOW(), totp_secret = NULL, totp_enabled_at = NULL,
+    totp_last_step = 0
+WHERE id = $1;
+
+-- name: UseTOTPStep :execrows
+-- Fails if a code for this or a later step was already used
+UPDATE users
+SET totp_last_step = $2
+WHERE id = $1 AND totp_last_step < $2;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (id, created_at, user_id, code_hash)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2
+);
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = $1;
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
@@ -17,8 +17,75 @@ RETURNING *;
 -- name: GetUserByEmail :one
 SELECT * FROM users WHERE email = $1;
 
--- name: UpdateUserEmailAndPassword :one
+-- name: UpdateUser :one
 UPDATE users
-SET updated_at = NOW(), email = $2, hashed_password = $3
+SET updated_at = NOW(),
+    email = COALESCE(sqlc.narg('email'), email),
+    email_verified_at = CASE WHEN COALESCE(sqlc.narg('email'), email) = email
+        THEN email_verified_at ELSE NULL END,
+    hashed_password = COALESCE(sqlc.narg('hashed_password'), hashed_password),
+    handle = COALESCE(sqlc.narg('handle'), handle),
+    display_name = COALESCE(sqlc.narg('display_name'), display_name),
+    bio = COALESCE(sqlc.narg('bio'), bio),
+    avatar_url = COALESCE(sqlc.narg('avatar_url'), avatar_url)
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- name: GetUserByID :one
+SELECT * FROM users WHERE id = $1;
+
+-- name: GetUserByHandle :one
+SELECT * FROM users WHERE handle = $1;
+
+-- name: RehashUserPassword :exec
+-- Only replaces the hash that was checked, so a password changed in the
+-- meantime isn't overwritten
+UPDATE users
+SET hashed_password = sqlc.arg('new_hash')
+WHERE id = sqlc.arg('id') AND hashed_password = sqlc.arg('old_hash');
+
+-- name: SetUserRole :one
+UPDATE users
+SET updated_at = NOW(), role = $2
 WHERE id = $1
 RETURNING *;
+
+-- name: HasAdmin :one
+SELECT EXISTS (SELECT 1 FROM users WHERE role = 'admin')::boolean AS has_admin;
+
+-- name: BootstrapAdmin :execrows
+-- Only while there is no admin, so it can't undo a later demotion, and only
+-- for a verified address so nobody can sign up with it first
+UPDATE users
+SET updated_at = NOW(), role = 'admin'
+WHERE email = $1 AND email_verified_at IS NOT NULL
+    AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin');
+
+-- name: ListStaff :many
+-- Users with a role above user
+SELECT *
+FROM users
+WHERE role <> 'user'
+ORDER BY email;
+
+-- name: ScheduleUserDeletion :one
+-- Asking again doesn't push back a deletion that is already scheduled
+UPDATE users
+SET updated_at = NOW(), delete_after = COALESCE(delete_after, $2)
+WHERE id = $1
+RETURNING *;
+
+-- name: CancelUserDeletion :execrows
+UPDATE users
+SET updated_at = NOW(), delete_after = NULL
+WHERE id = $1 AND delete_after IS NOT NULL;
+
+-- name: DeleteUser :execrows
+-- Everything else the user owns goes with them through ON DELETE CASCADE
+DELETE FROM users
+WHERE id = $1;
+
+-- name: DeleteDueUsers :many
+DELETE FROM users
+WHERE delete_after <= NOW()
+RETURNING id;
@@ -0,0 +1,58 @@
+-- name: CreateWebAuthnChallenge :exec
+INSERT INTO webauthn_challenges (challenge, created_at, user_id, ceremony, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4
+);
+
+-- name: UseWebAuthnChallenge :one
+-- Each challenge can only be answered once
+DELETE FROM webauthn_challenges
+WHERE challenge = $1 AND ceremony = $2 AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredWebAuthnChallenges :exec
+DELETE FROM webauthn_challenges
+WHERE expires_at <= NOW();
+
+-- name: CreateWebAuthnCredential :one
+INSERT INTO webauthn_credentials (id, created_at, user_id, name, credential_id,
+    public_key, sign_count, aaguid, backup_eligible)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+)
+RETURNING *;
+
+-- name: ListWebAuthnCredentials :many
+SELECT *
+FROM webauthn_credentials
+WHERE user_id = $1
+ORDER BY created_at DESC, id DESC;
+
+-- name: GetWebAuthnCredential :one
+SELECT * FROM webauthn_credentials WHERE credential_id = $1;
+
+-- name: UseWebAuthnCredential :execrows
+-- Fails if another login with the same credential got in first
+UPDATE webauthn_credentials
+SET sign_count = sqlc.arg('new_sign_count'), last_used_at = NOW()
+WHERE id = sqlc.arg('id') AND sign_count = sqlc.arg('old_sign_count');
+
+-- name: DeleteWebAuthnCredential :execrows
+DELETE FROM webauthn_credentials
+WHERE id = $1 AND user_id = $2;
+
+-- name: DeleteUserWebAuthnCredentials :exec
+DELETE FROM webauthn_credentials
+WHERE user_id = $1;
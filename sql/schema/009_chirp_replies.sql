@@ -0,0 +1,17 @@
+-- +goose Up
+-- in_reply_to has no foreign key: a parent deleted along with its author
+-- leaves replies pointing at an id that no longer exists, which is shown as
+-- a tombstone. Parents deleted by their author are kept as tombstones
+-- (deleted_at set, body cleared) while they still have replies
+ALTER TABLE chirps
+ADD COLUMN in_reply_to UUID,
+ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX chirps_in_reply_to_idx ON chirps (in_reply_to, created_at, id);
+
+-- +goose Down
+DROP INDEX chirps_in_reply_to_idx;
+
+ALTER TABLE chirps
+DROP COLUMN deleted_at,
+DROP COLUMN in_reply_to;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- A rechirp with an empty body is a plain rechirp, otherwise it quotes the
+-- original. Like in_reply_to there is no foreign key so reposts of deleted
+-- chirps remain and show the original as a tombstone
+ALTER TABLE chirps
+ADD COLUMN rechirp_of UUID;
+
+CREATE INDEX chirps_rechirp_of_idx ON chirps (rechirp_of);
+
+CREATE UNIQUE INDEX chirps_plain_rechirp_key ON chirps (user_id, rechirp_of)
+WHERE body = '' AND deleted_at IS NULL;
+
+-- +goose Down
+ALTER TABLE chirps
+DROP COLUMN rechirp_of;
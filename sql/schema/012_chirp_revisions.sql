@@ -0,0 +1,16 @@
+-- +goose Up
+-- created_at is when the body was written and replaced_at when an edit
+-- replaced it, so a chirp's history is its revisions followed by itself
+CREATE TABLE chirp_revisions (
+    id UUID UNIQUE NOT NULL PRIMARY KEY,
+    chirp_id UUID NOT NULL REFERENCES chirps ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    replaced_at TIMESTAMP NOT NULL,
+    body TEXT NOT NULL
+);
+
+CREATE INDEX chirp_revisions_chirp_id_idx
+ON chirp_revisions (chirp_id, replaced_at);
+
+-- +goose Down
+DROP TABLE chirp_revisions;
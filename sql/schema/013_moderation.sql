@@ -0,0 +1,21 @@
+-- +goose Up
+-- Words are stored normalized, see moderation.Normalize
+CREATE TABLE moderation_rules (
+    word TEXT UNIQUE NOT NULL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    action TEXT NOT NULL CHECK (action IN ('mask', 'reject', 'flag'))
+);
+
+CREATE TABLE chirp_flags (
+    id UUID UNIQUE NOT NULL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    chirp_id UUID NOT NULL REFERENCES chirps ON DELETE CASCADE,
+    reason TEXT NOT NULL
+);
+
+CREATE INDEX chirp_flags_created_at_idx ON chirp_flags (created_at, id);
+
+-- +goose Down
+DROP TABLE chirp_flags;
+DROP TABLE moderation_rules;
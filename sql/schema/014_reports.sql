@@ -0,0 +1,58 @@
+-- +goose Up
+ALTER TABLE chirps ADD COLUMN hidden_at TIMESTAMP;
+
+-- reporter_id is NULL for chirps flagged automatically by moderation rules
+CREATE TABLE reports (
+    id UUID UNIQUE NOT NULL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    chirp_id UUID NOT NULL REFERENCES chirps ON DELETE CASCADE,
+    reporter_id UUID REFERENCES users ON DELETE SET NULL,
+    reason TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'open'
+        CHECK (status IN ('open', 'resolved', 'dismissed')),
+    resolved_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX reports_open_reporter_key ON reports (chirp_id, reporter_id)
+WHERE status = 'open';
+CREATE INDEX reports_status_created_at_idx ON reports (status, created_at, id);
+
+INSERT INTO reports (id, created_at, updated_at, chirp_id, reason)
+SELECT id, created_at, created_at, chirp_id, reason FROM chirp_flags;
+
+DROP TABLE chirp_flags;
+
+-- Audit trail of moderator decisions
+-- chirp_id has no foreign key so entries outlive the chirps they refer to
+CREATE TABLE moderation_actions (
+    id UUID UNIQUE NOT NULL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    chirp_id UUID NOT NULL,
+    report_id UUID REFERENCES reports ON DELETE SET NULL,
+    action TEXT NOT NULL
+        CHECK (action IN ('hide', 'unhide', 'remove', 'resolve', 'dismiss')),
+    note TEXT NOT NULL
+);
+
+CREATE INDEX moderation_actions_chirp_id_idx ON moderation_actions (chirp_id, created_at);
+
+-- +goose Down
+DROP TABLE moderation_actions;
+
+CREATE TABLE chirp_flags (
+    id UUID UNIQUE NOT NULL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    chirp_id UUID NOT NULL REFERENCES chirps ON DELETE CASCADE,
+    reason TEXT NOT NULL
+);
+
+CREATE INDEX chirp_flags_created_at_idx ON chirp_flags (created_at, id);
+
+INSERT INTO chirp_flags (id, created_at, chirp_id, reason)
+SELECT id, created_at, chirp_id, reason FROM reports
+WHERE reporter_id IS NULL AND status = 'open';
+
+DROP TABLE reports;
+
+ALTER TABLE chirps DROP COLUMN hidden_at;
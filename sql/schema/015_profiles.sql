@@ -0,0 +1,15 @@
+-- +goose Up
+-- Existing users get a random placeholder handle they can change later
+ALTER TABLE users
+ADD COLUMN handle TEXT UNIQUE NOT NULL
+    DEFAULT 'user_' || substr(md5(random()::text), 1, 10),
+ADD COLUMN display_name TEXT NOT NULL DEFAULT '',
+ADD COLUMN bio TEXT NOT NULL DEFAULT '',
+ADD COLUMN avatar_url TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN handle,
+DROP COLUMN display_name,
+DROP COLUMN bio,
+DROP COLUMN avatar_url;
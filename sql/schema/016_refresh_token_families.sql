@@ -0,0 +1,15 @@
+-- +goose Up
+-- Every refresh replaces the token with a new one in the same family
+-- Tokens issued before rotation each start a family of their own
+ALTER TABLE refresh_tokens
+ADD COLUMN family_id UUID NOT NULL DEFAULT gen_random_uuid(),
+ADD COLUMN replaced_by TEXT;
+
+ALTER TABLE refresh_tokens ALTER COLUMN family_id DROP DEFAULT;
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+-- +goose Down
+ALTER TABLE refresh_tokens
+DROP COLUMN family_id,
+DROP COLUMN replaced_by;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- Access tokens revoked before they expire; rows can go once expires_at passes
+CREATE TABLE revoked_access_tokens (
+    jti TEXT UNIQUE NOT NULL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX revoked_access_tokens_expires_at_idx ON revoked_access_tokens (expires_at);
+
+-- Access tokens issued before this time are no longer accepted
+ALTER TABLE users ADD COLUMN tokens_valid_after TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN tokens_valid_after;
+
+DROP TABLE revoked_access_tokens;
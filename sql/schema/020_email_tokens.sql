@@ -0,0 +1,23 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+
+-- Single use tokens mailed to a user; only a hash is stored so a leaked
+-- table can't be used to take over accounts
+-- email is the address the token was sent to, so a verification token
+-- can't verify an address the user has since changed to
+CREATE TABLE email_tokens (
+    token_hash TEXT UNIQUE NOT NULL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    purpose TEXT NOT NULL CHECK (purpose IN ('password_reset', 'email_verification')),
+    email TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+CREATE INDEX email_tokens_user_id_idx ON email_tokens (user_id);
+
+-- +goose Down
+DROP TABLE email_tokens;
+
+ALTER TABLE users DROP COLUMN email_verified_at;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- Recent failed logins, counted separately per email address and per client
+-- IP; logins are refused until locked_until passes
+CREATE TABLE login_failures (
+    kind TEXT NOT NULL CHECK (kind IN ('account', 'ip')),
+    key TEXT NOT NULL,
+    failures INTEGER NOT NULL,
+    last_failed_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP,
+    PRIMARY KEY (kind, key)
+);
+
+-- +goose Down
+DROP TABLE login_failures;
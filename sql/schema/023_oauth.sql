@@ -0,0 +1,44 @@
+-- +goose Up
+-- Third-party apps users can authorize; public clients have no secret and
+-- rely on PKCE alone
+CREATE TABLE oauth_clients (
+    id UUID UNIQUE NOT NULL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    owner_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    secret_hash TEXT,
+    redirect_uris TEXT[] NOT NULL,
+    scopes TEXT[] NOT NULL
+);
+
+CREATE INDEX oauth_clients_owner_id_idx ON oauth_clients (owner_id);
+
+-- family_id is the refresh token family the code was exchanged for, so it
+-- can be revoked if the code is presented again
+CREATE TABLE oauth_authorization_codes (
+    code_hash TEXT UNIQUE NOT NULL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    client_id UUID NOT NULL REFERENCES oauth_clients ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    code_challenge TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    family_id UUID
+);
+
+-- Refresh tokens issued to a client carry its id and the granted scopes;
+-- tokens from logging in have neither
+ALTER TABLE refresh_tokens
+ADD COLUMN client_id UUID REFERENCES oauth_clients ON DELETE CASCADE,
+ADD COLUMN scopes TEXT[];
+
+-- +goose Down
+ALTER TABLE refresh_tokens
+DROP COLUMN client_id,
+DROP COLUMN scopes;
+
+DROP TABLE oauth_authorization_codes;
+DROP TABLE oauth_clients;
@@ -0,0 +1,31 @@
+-- +goose Up
+-- WebAuthn credentials; credential_id is chosen by the authenticator and
+-- sign_count is the last signature counter it reported
+CREATE TABLE webauthn_credentials (
+    id UUID UNIQUE NOT NULL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    credential_id BYTEA UNIQUE NOT NULL,
+    public_key BYTEA NOT NULL,
+    sign_count BIGINT NOT NULL,
+    aaguid BYTEA NOT NULL,
+    backup_eligible BOOLEAN NOT NULL,
+    last_used_at TIMESTAMP
+);
+
+CREATE INDEX webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);
+
+-- Challenges handed out for a ceremony and not yet answered; login
+-- challenges have no user since the passkey picks the account
+CREATE TABLE webauthn_challenges (
+    challenge TEXT UNIQUE NOT NULL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID REFERENCES users ON DELETE CASCADE,
+    ceremony TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE webauthn_challenges;
+DROP TABLE webauthn_credentials;
@@ -0,0 +1,25 @@
+-- +goose Up
+-- Set while an account is waiting out the grace period before it is deleted;
+-- logging in again cancels the deletion
+ALTER TABLE users ADD COLUMN delete_after TIMESTAMP;
+
+CREATE INDEX users_delete_after_idx ON users (delete_after)
+WHERE delete_after IS NOT NULL;
+
+-- Chirpy Red webhooks received for each user, kept for data exports
+-- Upgrades from before this table existed only show in users.is_chirpy_red
+CREATE TABLE subscription_events (
+    id UUID UNIQUE NOT NULL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    event TEXT NOT NULL
+);
+
+CREATE INDEX subscription_events_user_id_idx ON subscription_events (user_id, created_at);
+
+-- +goose Down
+DROP TABLE subscription_events;
+
+DROP INDEX users_delete_after_idx;
+
+ALTER TABLE users DROP COLUMN delete_after;
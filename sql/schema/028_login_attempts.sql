@@ -0,0 +1,12 @@
+-- +goose Up
+-- Attempts that have been let through but not yet checked, so a burst of
+-- parallel guesses can't all get past the lockout before the first failure is
+-- recorded. reserved_at is when the last one was let through
+ALTER TABLE login_failures
+ADD COLUMN pending INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN reserved_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE login_failures
+DROP COLUMN pending,
+DROP COLUMN reserved_at;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- The moderator who took each action; NULL for actions from before this was
+-- recorded and for moderators whose accounts were deleted
+ALTER TABLE moderation_actions
+ADD COLUMN moderator_id UUID REFERENCES users ON DELETE SET NULL;
+
+-- +goose Down
+ALTER TABLE moderation_actions
+DROP COLUMN moderator_id;